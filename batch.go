@@ -0,0 +1,239 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBatchConcurrency is the default number of operations run in parallel by batch methods.
+const DefaultBatchConcurrency = 4
+
+// runBatch calls op for each index in [0; n) using at most BatchConcurrency goroutines.
+// Results and errors are returned aligned by index.
+func (db *Dropbox) runBatch(n int, op func(i int) (*Entry, error)) ([]*Entry, []error) {
+	var wg sync.WaitGroup
+	var indexes chan int
+	var workers int
+
+	entries := make([]*Entry, n)
+	errs := make([]error, n)
+
+	if workers = db.BatchConcurrency; workers <= 0 {
+		workers = DefaultBatchConcurrency
+	}
+	if workers > n {
+		workers = n
+	}
+	indexes = make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				entries[i], errs[i] = op(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return entries, errs
+}
+
+// MoveBatch moves each pairs[i][0] to pairs[i][1].
+// The entries and errors returned are aligned with pairs.
+func (db *Dropbox) MoveBatch(pairs [][2]string) ([]*Entry, []error) {
+	return db.runBatch(len(pairs), func(i int) (*Entry, error) {
+		return db.Move(pairs[i][0], pairs[i][1])
+	})
+}
+
+// CopyBatch copies each pairs[i][0] to pairs[i][1], the sources are references from CopyRef if isRef is true.
+// The entries and errors returned are aligned with pairs.
+func (db *Dropbox) CopyBatch(pairs [][2]string, isRef bool) ([]*Entry, []error) {
+	return db.runBatch(len(pairs), func(i int) (*Entry, error) {
+		return db.Copy(pairs[i][0], pairs[i][1], isRef)
+	})
+}
+
+// DeleteBatch removes each path.
+// The entries and errors returned are aligned with paths.
+// If CoalesceDeletes is set, the paths sharing the same parent folder are removed with a single Delete of the folder
+// when a listing of the folder shows that all its children are in paths, the folder itself is then removed too.
+func (db *Dropbox) DeleteBatch(paths []string) ([]*Entry, []error) {
+	if !db.CoalesceDeletes {
+		return db.runBatch(len(paths), func(i int) (*Entry, error) {
+			return db.Delete(paths[i])
+		})
+	}
+	return db.deleteBatchCoalesced(paths)
+}
+
+// deleteBatchCoalesced implements DeleteBatch when CoalesceDeletes is set.
+func (db *Dropbox) deleteBatchCoalesced(paths []string) ([]*Entry, []error) {
+	var targets []string
+
+	entries := make([]*Entry, len(paths))
+	errs := make([]error, len(paths))
+	indexes := make(map[string][]int)     // Indexes in paths of each lowercase path.
+	children := make(map[string][]string) // Lowercase paths to delete in each folder.
+	listed := make(map[string][]Entry)    // Children of the folders deleted at once.
+	for i, p := range paths {
+		key := strings.ToLower(cleanPath(p))
+		if _, ok := indexes[key]; !ok {
+			if j := strings.LastIndex(key, "/"); j > 0 {
+				children[key[:j]] = append(children[key[:j]], key)
+			}
+		}
+		indexes[key] = append(indexes[key], i)
+	}
+	coalesced := make(map[string]bool)
+	for parent, keys := range children {
+		if _, ok := indexes[parent]; ok || len(keys) < 2 {
+			continue
+		}
+		if contents, ok := db.onlyChildren(parent, keys); ok {
+			listed[parent] = contents
+			for _, key := range keys {
+				coalesced[key] = true
+			}
+			targets = append(targets, parent)
+		}
+	}
+	for key := range indexes {
+		if !coalesced[key] {
+			targets = append(targets, key)
+		}
+	}
+
+	db.runBatch(len(targets), func(t int) (*Entry, error) {
+		entry, err := db.Delete(targets[t])
+		contents, ok := listed[targets[t]]
+		if !ok {
+			for _, i := range indexes[targets[t]] {
+				entries[i], errs[i] = entry, err
+			}
+			return nil, nil
+		}
+		for _, child := range contents {
+			for _, i := range indexes[strings.ToLower(cleanPath(child.Path))] {
+				if errs[i] = err; err == nil {
+					deleted := child
+					deleted.IsDeleted = true
+					entries[i] = &deleted
+				}
+			}
+		}
+		return nil, nil
+	})
+	return entries, errs
+}
+
+// onlyChildren returns the children of the folder parent if they are exactly the lowercase paths keys.
+func (db *Dropbox) onlyChildren(parent string, keys []string) ([]Entry, bool) {
+	entry, err := db.base().Metadata(parent, true, false, "", "", 0)
+	if err != nil || !entry.IsDir || len(entry.Contents) != len(keys) {
+		return nil, false
+	}
+	wanted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	for _, child := range entry.Contents {
+		if child.IsDeleted || !wanted[strings.ToLower(cleanPath(child.Path))] {
+			return nil, false
+		}
+		delete(wanted, strings.ToLower(cleanPath(child.Path)))
+	}
+	return entry.Contents, true
+}
+
+// RevisionsError is the error returned by FolderRevisions when the revisions of some files or the content
+// of some folders could not be listed, indexed by path.
+type RevisionsError map[string]error
+
+// Error satisfy the error interface.
+func (re RevisionsError) Error() string {
+	var paths []string
+
+	for path := range re {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return fmt.Sprintf("the revisions of %d entries could not be listed, first %s: %s", len(re), paths[0], re[paths[0]])
+}
+
+// FolderRevisions returns the revisions of each file in the tree rooted at folder, indexed by path, see Revisions
+// for perFileLimit. The revisions are requested in parallel like the batch methods.
+// An error on a file or a folder does not stop the listing, the revisions found are returned with a RevisionsError.
+func (db *Dropbox) FolderRevisions(folder string, perFileLimit int) (map[string][]Entry, error) {
+	var files []string
+	var started bool
+
+	failed := RevisionsError{}
+	err := db.Walk(folder, func(path string, entry *Entry, err error) error {
+		switch {
+		case err != nil && !started:
+			// The folder itself could not be read.
+			return err
+		case err != nil:
+			failed[path] = err
+		case !entry.IsDir && !entry.IsDeleted:
+			files = append(files, entry.Path)
+		}
+		started = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([][]Entry, len(files))
+	_, errs := db.runBatch(len(files), func(i int) (*Entry, error) {
+		var err error
+
+		revisions[i], err = db.Revisions(files[i], perFileLimit)
+		return nil, err
+	})
+	rv := make(map[string][]Entry, len(files))
+	for i, path := range files {
+		if errs[i] != nil {
+			failed[path] = errs[i]
+		} else {
+			rv[path] = revisions[i]
+		}
+	}
+	if len(failed) != 0 {
+		return rv, failed
+	}
+	return rv, nil
+}
@@ -0,0 +1,246 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDeleteBatch(t *testing.T) {
+	var db *Dropbox
+	var paths []string
+
+	expected := dirEntry
+	expected.IsDeleted = true
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	db.BatchConcurrency = 2
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "POST",
+			Host:   "api.dropbox.com",
+			Path:   "/1/fileops/delete",
+			Params: map[string]string{
+				"root":   "auto",
+				"path":   "testdir",
+				"locale": "en",
+			},
+			ResponseData: js,
+		},
+	}
+
+	paths = []string{"testdir", "testdir", "testdir", "testdir", "testdir"}
+	entries, errs := db.DeleteBatch(paths)
+	if len(entries) != len(paths) || len(errs) != len(paths) {
+		t.Fatalf("got %d entries and %d errors expected %d", len(entries), len(errs), len(paths))
+	}
+	for i := range paths {
+		if errs[i] != nil {
+			t.Errorf("API error: %s", errs[i])
+		} else if !reflect.DeepEqual(expected, *entries[i]) {
+			t.Errorf("got %#v expected %#v", *entries[i], expected)
+		}
+	}
+}
+
+func TestCopyBatch(t *testing.T) {
+	var db *Dropbox
+	var limiter countingLimiter
+
+	expected := fileEntry
+	expected.Path = "/copy"
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	db.BatchConcurrency = 1
+	db.RateLimit = &limiter
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "POST",
+			Host:   "api.dropbox.com",
+			Path:   "/1/fileops/copy",
+			Params: map[string]string{
+				"root":          "auto",
+				"from_copy_ref": "z1X6ATl6aWtzZGRwZ3lj",
+				"to_path":       "copy",
+				"locale":        "en",
+			},
+			ResponseData: js,
+		},
+	}
+
+	pairs := [][2]string{{"z1X6ATl6aWtzZGRwZ3lj", "copy"}, {"z1X6ATl6aWtzZGRwZ3lj", "copy"}, {"z1X6ATl6aWtzZGRwZ3lj", "copy"}}
+	entries, errs := db.CopyBatch(pairs, true)
+	if len(entries) != len(pairs) || len(errs) != len(pairs) {
+		t.Fatalf("got %d entries and %d errors expected %d", len(entries), len(errs), len(pairs))
+	}
+	for i := range pairs {
+		if errs[i] != nil {
+			t.Errorf("API error: %s", errs[i])
+		} else if !reflect.DeepEqual(expected, *entries[i]) {
+			t.Errorf("got %#v expected %#v", *entries[i], expected)
+		}
+	}
+	if limiter.calls != len(pairs) {
+		t.Errorf("got %d calls to the rate limiter expected %d", limiter.calls, len(pairs))
+	}
+}
+
+// folderHTTP lists the files of a single folder and records the paths deleted.
+type folderHTTP struct {
+	folder  string
+	files   []string
+	deleted *[]string
+	lock    *sync.Mutex
+}
+
+func (f folderHTTP) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+
+	switch {
+	case req.URL.Path == "/1/metadata/auto/"+f.folder:
+		entry := Entry{Path: "/" + f.folder, IsDir: true}
+		for _, name := range f.files {
+			entry.Contents = append(entry.Contents, Entry{Path: "/" + f.folder + "/" + name})
+		}
+		body, _ = json.Marshal(entry)
+	case req.URL.Path == "/1/fileops/delete":
+		f.lock.Lock()
+		*f.deleted = append(*f.deleted, req.URL.Query().Get("path"))
+		f.lock.Unlock()
+		body, _ = json.Marshal(Entry{Path: "/" + req.URL.Query().Get("path"), IsDeleted: true})
+	default:
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader(`{"error": "not found"}`))}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(string(body)))}, nil
+}
+
+func TestDeleteBatchCoalesce(t *testing.T) {
+	var db *Dropbox
+	var deleted []string
+	var lock sync.Mutex
+
+	tab := []struct {
+		paths    []string
+		expected []string
+	}{
+		{[]string{"dir/a", "dir/b"}, []string{"dir/a", "dir/b"}},
+		{[]string{"dir/a", "dir/b", "Dir/C", "other"}, []string{"dir", "other"}},
+		{[]string{"dir/a"}, []string{"dir/a"}},
+	}
+
+	db = newDropbox(t)
+	db.CoalesceDeletes = true
+	http.DefaultClient = &http.Client{
+		Transport: folderHTTP{folder: "dir", files: []string{"a", "b", "c"}, deleted: &deleted, lock: &lock},
+	}
+	for _, tc := range tab {
+		deleted = nil
+		entries, errs := db.DeleteBatch(tc.paths)
+		sort.Strings(deleted)
+		if !reflect.DeepEqual(deleted, tc.expected) {
+			t.Errorf("%v: got deletions %v expected %v", tc.paths, deleted, tc.expected)
+		}
+		for i := range tc.paths {
+			if errs[i] != nil {
+				t.Errorf("%s: API error: %s", tc.paths[i], errs[i])
+			} else if entries[i] == nil || !entries[i].IsDeleted {
+				t.Errorf("%s: got %#v expected a deleted entry", tc.paths[i], entries[i])
+			}
+		}
+	}
+}
+
+// revisionsHTTP serves the revisions of the files of a treeHTTP, the files without revisions are not found.
+type revisionsHTTP struct {
+	treeHTTP
+	revisions map[string][]Entry
+}
+
+func (r revisionsHTTP) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := strings.TrimPrefix(req.URL.Path, "/1/revisions/auto/")
+	if name == req.URL.Path {
+		return r.treeHTTP.RoundTrip(req)
+	}
+	revisions, ok := r.revisions[name]
+	if !ok {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader(`{"error": "not found"}`))}, nil
+	}
+	body, _ := json.Marshal(revisions)
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(string(body)))}, nil
+}
+
+func TestFolderRevisions(t *testing.T) {
+	var db *Dropbox
+	var re RevisionsError
+
+	revisions := map[string][]Entry{
+		"docs/a.txt":     {{Path: "/docs/a.txt", Revision: "2"}, {Path: "/docs/a.txt", Revision: "1"}},
+		"docs/sub/b.txt": {{Path: "/docs/sub/b.txt", Revision: "3"}},
+	}
+	db = newDropbox(t)
+	db.BatchConcurrency = 2
+	http.DefaultClient = &http.Client{
+		Transport: revisionsHTTP{
+			treeHTTP: treeHTTP{
+				files: map[string]string{"docs/a.txt": "a", "docs/sub/b.txt": "b", "docs/broken.txt": "c", "other.txt": "d"},
+				dirs:  map[string]bool{"": true, "docs": true, "docs/sub": true},
+			},
+			revisions: revisions,
+		},
+	}
+
+	received, err := db.FolderRevisions("docs", 10)
+	if !errors.As(err, &re) || len(re) != 1 || re["/docs/broken.txt"] == nil {
+		t.Errorf("got %v expected an error for /docs/broken.txt", err)
+	}
+	expected := map[string][]Entry{"/docs/a.txt": revisions["docs/a.txt"], "/docs/sub/b.txt": revisions["docs/sub/b.txt"]}
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("got %#v expected %#v", received, expected)
+	}
+
+	if _, err = db.FolderRevisions("missing", 10); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v expected os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+)
+
+// ContentHashBlockSize is the size of the blocks used to compute the content hash.
+const ContentHashBlockSize = 4 * 1024 * 1024
+
+// ErrContentHashMismatch is the error returned when the content hash of a transferred file does not match the local data.
+var ErrContentHashMismatch = errors.New("content hash mismatch")
+
+// contentHasher computes the Dropbox content hash, the SHA-256 of the concatenated SHA-256 of each block.
+type contentHasher struct {
+	digests []byte    // SHA-256 of the completed blocks.
+	block   hash.Hash // SHA-256 of the current block.
+	size    int       // Number of bytes in the current block.
+}
+
+// newContentHasher returns a hash.Hash computing the Dropbox content hash.
+func newContentHasher() hash.Hash {
+	return &contentHasher{block: sha256.New()}
+}
+
+// Write adds more data to the running hash.
+func (h *contentHasher) Write(p []byte) (int, error) {
+	var n int
+
+	for len(p) > 0 {
+		if h.size == ContentHashBlockSize {
+			h.digests = h.block.Sum(h.digests)
+			h.block.Reset()
+			h.size = 0
+		}
+		l := ContentHashBlockSize - h.size
+		if l > len(p) {
+			l = len(p)
+		}
+		h.block.Write(p[:l])
+		h.size += l
+		n += l
+		p = p[l:]
+	}
+	return n, nil
+}
+
+// Sum appends the current hash to b and returns the resulting slice.
+func (h *contentHasher) Sum(b []byte) []byte {
+	overall := sha256.New()
+	overall.Write(h.digests)
+	if h.size > 0 {
+		overall.Write(h.block.Sum(nil))
+	}
+	return overall.Sum(b)
+}
+
+// Reset resets the hash to its initial state.
+func (h *contentHasher) Reset() {
+	h.digests = h.digests[:0]
+	h.block.Reset()
+	h.size = 0
+}
+
+// Size returns the number of bytes Sum will return.
+func (h *contentHasher) Size() int {
+	return sha256.Size
+}
+
+// BlockSize returns the hash's underlying block size.
+func (h *contentHasher) BlockSize() int {
+	return ContentHashBlockSize
+}
+
+// ContentHash computes the Dropbox content hash of the data read from r.
+func ContentHash(r io.Reader) (string, error) {
+	h := newContentHasher()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
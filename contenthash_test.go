@@ -0,0 +1,151 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentHash(t *testing.T) {
+	var fixture []byte
+
+	fixture = make([]byte, 5*1024*1024+7)
+	for i := range fixture {
+		fixture[i] = byte(i % 251)
+	}
+
+	tab := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte{}, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{[]byte("file content"), "7010da024b3bbb581ca2e65653e45dee902d73851987b9cfb79c6583bfeec6f1"},
+		{fixture, "7437e5f586f8768310d7516f54dd3894cc5f748e41714257b77bf1f7cf78d436"},
+	}
+
+	for _, testCase := range tab {
+		if received, err := ContentHash(bytes.NewReader(testCase.data)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if received != testCase.expected {
+			t.Errorf("got %s expected %s for %d bytes", received, testCase.expected, len(testCase.data))
+		}
+	}
+}
+
+func TestEntryContentHash(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var entry *Entry
+	var body io.ReadCloser
+
+	sum := "7010da024b3bbb581ca2e65653e45dee902d73851987b9cfb79c6583bfeec6f1"
+	js := []byte(`{"path": "/testfile", "bytes": 12, "content_hash": "` + sum + `"}`)
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/metadata/auto/testfile",
+			Params:       map[string]string{"list": "false", "include_deleted": "false", "file_limit": "10000", "locale": "en"},
+			ResponseData: js,
+		},
+	}
+	if entry, err = db.Metadata("testfile", false, false, "", "", 0); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if entry.ContentHash != sum {
+		t.Errorf("got %q expected %q", entry.ContentHash, sum)
+	}
+
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:              t,
+			Method:         "GET",
+			Host:           "api-content.dropbox.com",
+			Path:           "/1/files/auto/testfile",
+			ResponseData:   []byte("file content"),
+			ResponseHeader: http.Header{"X-Dropbox-Metadata": {string(js)}},
+		},
+	}
+	if body, _, entry, err = db.DownloadWithMetadata("testfile", "", 0); err != nil {
+		t.Errorf("API error: %s", err)
+	} else {
+		body.Close()
+		if entry == nil || entry.ContentHash != sum {
+			t.Errorf("got %#v expected the content hash %q", entry, sum)
+		}
+	}
+}
+
+func TestDownloadToFileVerified(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var tmpdir string
+
+	content := []byte("file content")
+	sum, err := ContentHash(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("could not compute the content hash: %s", err)
+	}
+	if tmpdir, err = ioutil.TempDir("", "dropbox"); err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dst := filepath.Join(tmpdir, "testfile")
+
+	db = newDropbox(t)
+	fake := FakeHTTP{
+		t:              t,
+		Method:         "GET",
+		Host:           "api-content.dropbox.com",
+		Path:           "/1/files/auto/testfile",
+		ResponseData:   content,
+		ResponseHeader: http.Header{"X-Dropbox-Metadata": {`{"path": "/testfile", "content_hash": "` + sum + `"}`}},
+	}
+	http.DefaultClient = &http.Client{Transport: fake}
+	if err = db.DownloadToFileVerified("testfile", dst, "", ""); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if received, _ := ioutil.ReadFile(dst); !bytes.Equal(received, content) {
+		t.Errorf("got %q expected %q", received, content)
+	}
+
+	fake.ResponseData = []byte("file c0ntent")
+	http.DefaultClient = &http.Client{Transport: fake}
+	if err = db.DownloadToFileVerified("testfile", dst, "", sum); !errors.Is(err, ErrContentHashMismatch) {
+		t.Errorf("got %v expected ErrContentHashMismatch", err)
+	}
+	if _, err = os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("the corrupted file must be removed: %v", err)
+	}
+}
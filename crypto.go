@@ -171,7 +171,7 @@ func (db *Dropbox) FilesPutAES(key []byte, input io.ReadCloser, size int64, dst
 	if encreader, outsize, err = NewAESCrypterReader(key, input, int(size)); err != nil {
 		return nil, err
 	}
-	return db.FilesPut(encreader, int64(outsize), dst, overwrite, parentRev)
+	return db.base().FilesPut(encreader, int64(outsize), dst, overwrite, parentRev)
 }
 
 // UploadFileAES uploads and encrypts the file located in the src path on the local disk to the dst path on Dropbox.
@@ -199,7 +199,7 @@ func (db *Dropbox) DownloadAES(key []byte, src, rev string, offset int) (io.Read
 	var size int64
 	var err error
 
-	if in, size, err = db.Download(src, rev, int64(offset)); err != nil {
+	if in, size, err = db.base().Download(src, rev, int64(offset)); err != nil {
 		return nil, err
 	}
 	return NewAESDecrypterReader(key, in, int(size))
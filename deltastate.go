@@ -0,0 +1,139 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DeltaState is the local state of the files built from the pages returned by Delta.
+// Paths are compared in the form returned by NormalizePath like Delta does, it is safe for concurrent use.
+type DeltaState struct {
+	cursor  string
+	entries map[string]Entry
+	lock    sync.RWMutex
+}
+
+// deltaStateJSON is the persisted form of a DeltaState.
+type deltaStateJSON struct {
+	Cursor  string           `json:"cursor"`
+	Entries map[string]Entry `json:"entries"`
+}
+
+// Apply merges the changes of page into the state and saves its cursor.
+// The state is cleared first if page.Reset is set. A removed entry also removes its children,
+// as does a file replacing a directory, while a directory update keeps its children.
+func (ds *DeltaState) Apply(page *DeltaPage) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	if ds.entries == nil || page.Reset {
+		ds.entries = make(map[string]Entry)
+	}
+	for _, de := range page.Entries {
+		key := NormalizePath(de.Path)
+		if de.Entry == nil || !de.Entry.IsDir {
+			ds.removeTree(key)
+		}
+		if de.Entry != nil {
+			ds.entries[key] = *de.Entry
+		}
+	}
+	ds.cursor = page.Cursor.Cursor
+}
+
+// removeTree removes key and all its children.
+func (ds *DeltaState) removeTree(key string) {
+	delete(ds.entries, key)
+	prefix := key + "/"
+	for path := range ds.entries {
+		if strings.HasPrefix(path, prefix) {
+			delete(ds.entries, path)
+		}
+	}
+}
+
+// Get returns the entry of path, false if it is not in the state.
+func (ds *DeltaState) Get(path string) (*Entry, bool) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	entry, ok := ds.entries[NormalizePath(path)]
+	if !ok {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// Paths returns the sorted normalized paths (see NormalizePath) of all the entries of the state.
+func (ds *DeltaState) Paths() []string {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	rv := make([]string, 0, len(ds.entries))
+	for path := range ds.entries {
+		rv = append(rv, path)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Cursor returns the cursor of the last page applied, it should be given to Delta to get the next changes.
+func (ds *DeltaState) Cursor() string {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	return ds.cursor
+}
+
+// MarshalJSON saves the cursor and the entries of the state.
+func (ds *DeltaState) MarshalJSON() ([]byte, error) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	return json.Marshal(deltaStateJSON{Cursor: ds.cursor, Entries: ds.entries})
+}
+
+// UnmarshalJSON restores a state saved with MarshalJSON.
+func (ds *DeltaState) UnmarshalJSON(data []byte) error {
+	var saved deltaStateJSON
+
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	ds.cursor = saved.Cursor
+	ds.entries = make(map[string]Entry, len(saved.Entries))
+	for path, entry := range saved.Entries {
+		ds.entries[NormalizePath(path)] = entry
+	}
+	return nil
+}
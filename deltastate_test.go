@@ -0,0 +1,125 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDeltaState(t *testing.T) {
+	var state, restored DeltaState
+
+	dir := func(path string) *Entry { return &Entry{Path: path, IsDir: true} }
+	file := func(path string) *Entry { return &Entry{Path: path, Revision: "1"} }
+	check := func(expected ...string) {
+		if received := state.Paths(); !reflect.DeepEqual(received, expected) {
+			t.Errorf("got %v expected %v", received, expected)
+		}
+	}
+
+	state.Apply(&DeltaPage{Cursor: Cursor{"first"}, Entries: []DeltaEntry{
+		{Path: "/a", Entry: dir("/A")},
+		{Path: "/a/b", Entry: dir("/A/b")},
+		{Path: "/a/b/c.txt", Entry: file("/A/b/c.txt")},
+		{Path: "/d.txt", Entry: file("/d.txt")},
+	}})
+	check("/a", "/a/b", "/a/b/c.txt", "/d.txt")
+	if state.Cursor() != "first" {
+		t.Errorf("got cursor %s expected first", state.Cursor())
+	}
+	if entry, ok := state.Get("A/B/c.txt"); !ok || entry.Path != "/A/b/c.txt" {
+		t.Errorf("got %v %v expected the entry of /A/b/c.txt", entry, ok)
+	}
+
+	// Updating a directory keeps its children, removing it removes them.
+	state.Apply(&DeltaPage{Cursor: Cursor{"second"}, Entries: []DeltaEntry{
+		{Path: "/a", Entry: dir("/a")},
+	}})
+	check("/a", "/a/b", "/a/b/c.txt", "/d.txt")
+	state.Apply(&DeltaPage{Cursor: Cursor{"third"}, Entries: []DeltaEntry{
+		{Path: "/a/b", Entry: nil},
+		{Path: "/missing", Entry: nil},
+	}})
+	check("/a", "/d.txt")
+	if _, ok := state.Get("/a/b/c.txt"); ok {
+		t.Errorf("children of a deleted directory must be removed")
+	}
+
+	// A file replacing a directory removes its children.
+	state.Apply(&DeltaPage{Cursor: Cursor{"fourth"}, Entries: []DeltaEntry{
+		{Path: "/a/e", Entry: file("/a/e")},
+		{Path: "/a", Entry: file("/a")},
+	}})
+	check("/a", "/d.txt")
+
+	js, err := json.Marshal(&state)
+	if err != nil {
+		t.Fatalf("could not marshal the state: %s", err)
+	}
+	if err = json.Unmarshal(js, &restored); err != nil {
+		t.Fatalf("could not unmarshal the state: %s", err)
+	}
+	if !reflect.DeepEqual(restored.Paths(), state.Paths()) || restored.Cursor() != "fourth" {
+		t.Errorf("got %v %s expected %v fourth", restored.Paths(), restored.Cursor(), state.Paths())
+	}
+
+	state.Apply(&DeltaPage{Reset: true, Cursor: Cursor{"fifth"}, Entries: []DeltaEntry{
+		{Path: "/f", Entry: file("/f")},
+	}})
+	check("/f")
+	if state.Cursor() != "fifth" {
+		t.Errorf("got cursor %s expected fifth", state.Cursor())
+	}
+}
+
+func TestDeltaStateCase(t *testing.T) {
+	var state DeltaState
+
+	// The paths of the delta entries are lowercase while the entries preserve the case.
+	state.Apply(&DeltaPage{Cursor: Cursor{"first"}, Entries: []DeltaEntry{
+		{Path: "/docs", Entry: &Entry{Path: "/Docs", IsDir: true}},
+		{Path: "/docs/report.pdf", Entry: &Entry{Path: "/Docs/Report.pdf", Revision: "1"}},
+	}})
+	for _, path := range []string{"/Docs/Report.pdf", "docs/report.pdf", "/DOCS//REPORT.PDF"} {
+		if entry, ok := state.Get(path); !ok || entry.Path != "/Docs/Report.pdf" {
+			t.Errorf("%s: got %v %v expected the entry of /Docs/Report.pdf", path, entry, ok)
+		}
+	}
+
+	// A rename changing only the case updates the entries in place.
+	state.Apply(&DeltaPage{Cursor: Cursor{"second"}, Entries: []DeltaEntry{
+		{Path: "/docs", Entry: &Entry{Path: "/DOCS", IsDir: true}},
+		{Path: "/docs/report.pdf", Entry: &Entry{Path: "/DOCS/report.PDF", Revision: "2"}},
+	}})
+	if received, expected := state.Paths(), []string{"/docs", "/docs/report.pdf"}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("got %v expected %v", received, expected)
+	}
+	if entry, ok := state.Get(NormalizePath("/Docs/Report.pdf")); !ok || entry.Path != "/DOCS/report.PDF" || entry.Revision != "2" {
+		t.Errorf("got %v %v expected the renamed entry", entry, ok)
+	}
+}
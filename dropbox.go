@@ -27,16 +27,22 @@
 package dropbox
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"bytes"
 
@@ -47,6 +53,84 @@ import (
 // ErrNotAuth is the error returned when the OAuth token is not provided
 var ErrNotAuth = errors.New("authentication required")
 
+// ErrNotModified is the error returned by Metadata when the hash given matches the current state of the directory.
+var ErrNotModified = errors.New("not modified")
+
+// ErrTargetExists is matched by the errors returned by Copy, Move or CreateFolder when the destination already exists.
+var ErrTargetExists = errors.New("target already exists")
+
+// ErrNotSupported is matched by the errors returned when a feature is not available for the account.
+var ErrNotSupported = errors.New("not supported on this account")
+
+// ErrInsufficientStorage is matched by the errors returned when the account has no space left for the operation (507).
+var ErrInsufficientStorage = errors.New("insufficient storage")
+
+// ErrUploadComplete is returned by ChunkedUpload with a valid session once input is exhausted, the upload can be committed.
+// It also matches io.EOF with errors.Is for the callers written when ChunkedUpload returned io.EOF.
+var ErrUploadComplete error = uploadCompleteError{}
+
+// uploadCompleteError is the type of ErrUploadComplete.
+type uploadCompleteError struct{}
+
+func (uploadCompleteError) Error() string { return "upload complete" }
+
+// Is reports whether target is io.EOF.
+func (uploadCompleteError) Is(target error) bool { return target == io.EOF }
+
+// ErrConflict is matched by the errors returned by the uploads when the destination was modified concurrently,
+// see ConflictError.
+var ErrConflict = errors.New("conflict")
+
+// ConflictError is the error returned by the uploads when the server rejected the data because the destination
+// exists or was modified since the parent revision given. If ReportConflicts is set, it is also returned with
+// the entry of the conflicted copy when the server saved the data next to the destination.
+type ConflictError struct {
+	Path     string // Destination of the upload.
+	CopyPath string // Path of the conflicted copy created by the server, empty if none.
+	Err      error  // Error returned by the server when the upload was rejected.
+}
+
+func (e *ConflictError) Error() string {
+	if len(e.CopyPath) != 0 {
+		return fmt.Sprintf("conflict on '%s': data saved to '%s'", e.Path, e.CopyPath)
+	}
+	return fmt.Sprintf("conflict on '%s': %v", e.Path, e.Err)
+}
+
+// Is reports whether target is ErrConflict.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Unwrap returns the error of the server, ErrTargetExists is then still matched.
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// ErrMalformedDelta is matched by the errors returned by Delta when an entry of the reply cannot be decoded.
+var ErrMalformedDelta = errors.New("malformed delta entry")
+
+// MalformedDeltaError is the error returned by Delta for an entry that cannot be decoded when StrictDelta is set.
+type MalformedDeltaError struct {
+	Raw json.RawMessage // Entry as sent by the server.
+	Err error           // Reason of the failure.
+}
+
+// Error satisfy the error interface.
+func (e *MalformedDeltaError) Error() string {
+	return fmt.Sprintf("%s %s: %v", ErrMalformedDelta, e.Raw, e.Err)
+}
+
+// Is reports whether target is ErrMalformedDelta.
+func (e *MalformedDeltaError) Is(target error) bool {
+	return target == ErrMalformedDelta
+}
+
+// Unwrap returns the reason of the failure.
+func (e *MalformedDeltaError) Unwrap() error {
+	return e.Err
+}
+
 // Account represents information about the user account.
 type Account struct {
 	ReferralLink string `json:"referral_link,omitempty"` // URL for referral.
@@ -60,12 +144,71 @@ type Account struct {
 	} `json:"quota_info"`
 }
 
+// Used returns the number of bytes used by this account.
+func (a *Account) Used() int64 {
+	return a.QuotaInfo.Normal + a.QuotaInfo.Shared
+}
+
+// Free returns the number of bytes still available on this account.
+func (a *Account) Free() int64 {
+	return a.QuotaInfo.Quota - a.Used()
+}
+
+// UsedFraction returns the fraction of the quota in use, 0 if the quota is unknown.
+func (a *Account) UsedFraction() float64 {
+	if a.QuotaInfo.Quota == 0 {
+		return 0
+	}
+	return float64(a.Used()) / float64(a.QuotaInfo.Quota)
+}
+
+// SpaceAllocation is the space allocated to an account, Type is individual or team.
+// The team fields are only set for team accounts with the version 2 of the API.
+type SpaceAllocation struct {
+	Type          string // individual or team.
+	Allocated     int64  // Bytes allocated to the account or shared by the team.
+	TeamUsed      int64  // Bytes used by the whole team.
+	UserAllocated int64  // Bytes the user may use in the team space, 0 if unlimited.
+	UserLimitType string // Action taken when the user reaches UserAllocated: off, alert_only or stop_sync.
+}
+
+// SpaceUsage is the space used and allocated for the account, see SpaceUsage.
+type SpaceUsage struct {
+	Used       int64 // Bytes used by the user.
+	Allocation SpaceAllocation
+}
+
+// SpaceUsage returns the space usage of the account.
+// The version 1 of the API has no team information, the usage is derived from the quota of GetAccountInfo.
+func (db *Dropbox) SpaceUsage() (*SpaceUsage, error) {
+	account, err := db.base().GetAccountInfo()
+	if err != nil {
+		return nil, err
+	}
+	return &SpaceUsage{
+		Used:       account.Used(),
+		Allocation: SpaceAllocation{Type: "individual", Allocated: account.QuotaInfo.Quota},
+	}, nil
+}
+
 // CopyRef represents the reply of CopyRef.
 type CopyRef struct {
 	CopyRef string `json:"copy_ref"` // Reference to use on fileops/copy.
 	Expires string `json:"expires"`  // Expiration date.
 }
 
+// ExpiresTime returns the expiration date of the reference, the zero time if not set.
+func (c *CopyRef) ExpiresTime() (time.Time, error) {
+	if len(c.Expires) == 0 {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(DateFormat, c.Expires, time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiration date %q: %w", c.Expires, err)
+	}
+	return t, nil
+}
+
 // DeltaPage represents the reply of delta.
 type DeltaPage struct {
 	Reset   bool         // if true the local state must be cleared.
@@ -78,6 +221,7 @@ type DeltaPage struct {
 type DeltaEntry struct {
 	Path  string // Path of this entry in lowercase.
 	Entry *Entry // nil when this entry does not exists.
+	Reset bool   // Only set by Watch, without Path nor Entry, when the local state must be cleared.
 }
 
 // DeltaPoll represents the reply of longpoll_delta.
@@ -111,6 +255,8 @@ const (
 	PollMinTimeout = 30
 	// PollMaxTimeout is the maximum timeout for longpoll.
 	PollMaxTimeout = 480
+	// PollTimeoutMargin is the number of seconds added to the timeout of a longpoll to limit its request.
+	PollTimeoutMargin = 90
 	// DefaultChunkSize is the maximum size of a file sendable using files_put.
 	DefaultChunkSize = 4 * 1024 * 1024
 	// MaxPutFileSize is the maximum size of a file sendable using files_put.
@@ -127,6 +273,8 @@ const (
 	SearchLimitMax = 1000
 	// SearchLimitDefault is the default number of entries returned by search.
 	SearchLimitDefault = 1000
+	// DefaultMaxGetFileSize is the default maximum size of a file read by GetFile.
+	DefaultMaxGetFileSize = 32 * 1024 * 1024
 	// DateFormat is the format to use when decoding a time.
 	DateFormat = time.RFC1123Z
 )
@@ -143,6 +291,10 @@ func (dbt *DBTime) UnmarshalJSON(data []byte) error {
 	if err = json.Unmarshal(data, &s); err != nil {
 		return err
 	}
+	if len(s) == 0 {
+		*dbt = DBTime(time.Time{})
+		return nil
+	}
 	if t, err = time.ParseInLocation(DateFormat, s, time.UTC); err != nil {
 		return err
 	}
@@ -167,28 +319,102 @@ type Modifier struct {
 
 // Entry represents the metadata of a file or folder.
 type Entry struct {
-	Bytes                int64     `json:"bytes,omitempty"`        // Size of the file in bytes.
-	ClientMtime          DBTime    `json:"client_mtime,omitempty"` // Modification time set by the client when added.
-	Contents             []Entry   `json:"contents,omitempty"`     // List of children for a directory.
-	Hash                 string    `json:"hash,omitempty"`         // Hash of this entry.
-	Icon                 string    `json:"icon,omitempty"`         // Name of the icon displayed for this entry.
-	IsDeleted            bool      `json:"is_deleted,omitempty"`   // true if this entry was deleted.
-	IsDir                bool      `json:"is_dir,omitempty"`       // true if this entry is a directory.
-	MimeType             string    `json:"mime_type,omitempty"`    // MimeType of this entry.
-	Modified             DBTime    `json:"modified,omitempty"`     // Date of last modification.
-	Path                 string    `json:"path,omitempty"`         // Absolute path of this entry.
-	Revision             string    `json:"rev,omitempty"`          // Unique ID for this file revision.
-	Root                 string    `json:"root,omitempty"`         // dropbox or sandbox.
-	Size                 string    `json:"size,omitempty"`         // Size of the file humanized/localized.
-	ThumbExists          bool      `json:"thumb_exists,omitempty"` // true if a thumbnail is available for this entry.
-	Modifier             *Modifier `json:"modifier"`               // last user to edit the file if in a shared folder
-	ParentSharedFolderID string    `json:"parent_shared_folder_id,omitempty"`
+	Bytes                int64      `json:"bytes,omitempty"`        // Size of the file in bytes.
+	ClientMtime          DBTime     `json:"client_mtime,omitempty"` // Modification time set by the client when added.
+	ContentHash          string     `json:"content_hash,omitempty"` // Dropbox content hash of the file (see ContentHash).
+	Contents             []Entry    `json:"contents,omitempty"`     // List of children for a directory.
+	Hash                 string     `json:"hash,omitempty"`         // Hash of this entry.
+	Icon                 string     `json:"icon,omitempty"`         // Name of the icon displayed for this entry.
+	IsDeleted            bool       `json:"is_deleted,omitempty"`   // true if this entry was deleted.
+	IsDir                bool       `json:"is_dir,omitempty"`       // true if this entry is a directory.
+	MimeType             string     `json:"mime_type,omitempty"`    // MimeType of this entry.
+	Modified             DBTime     `json:"modified,omitempty"`     // Date of last modification.
+	Path                 string     `json:"path,omitempty"`         // Absolute path of this entry.
+	Revision             string     `json:"rev,omitempty"`          // Unique ID for this file revision.
+	Root                 string     `json:"root,omitempty"`         // dropbox or sandbox.
+	Size                 string     `json:"size,omitempty"`         // Size of the file humanized/localized.
+	ThumbExists          bool       `json:"thumb_exists,omitempty"` // true if a thumbnail is available for this entry.
+	Modifier             *Modifier  `json:"modifier"`               // last user to edit the file if in a shared folder
+	ParentSharedFolderID string     `json:"parent_shared_folder_id,omitempty"`
+	PhotoInfo            *MediaInfo `json:"photo_info,omitempty"` // Metadata of a photo when requested.
+	VideoInfo            *MediaInfo `json:"video_info,omitempty"` // Metadata of a video when requested.
+}
+
+// MediaInfo represents the metadata of a photo or a video.
+type MediaInfo struct {
+	Pending   bool      `json:"-"`                    // true if the metadata is not extracted yet.
+	LatLong   []float64 `json:"lat_long,omitempty"`   // Latitude and longitude where it was taken.
+	TimeTaken DBTime    `json:"time_taken,omitempty"` // Date it was taken.
+	Duration  int64     `json:"duration,omitempty"`   // Duration of a video in milliseconds.
+}
+
+// UnmarshalJSON unmarshals the media info, the server sends "pending" while the metadata is being extracted.
+func (mi *MediaInfo) UnmarshalJSON(data []byte) error {
+	type mediaInfo MediaInfo
+	var s string
+
+	if json.Unmarshal(data, &s) == nil {
+		*mi = MediaInfo{Pending: s == "pending"}
+		return nil
+	}
+	return json.Unmarshal(data, (*mediaInfo)(mi))
+}
+
+// MarshalJSON marshals the media info in the format sent by the server.
+func (mi MediaInfo) MarshalJSON() ([]byte, error) {
+	type mediaInfo MediaInfo
+
+	if mi.Pending {
+		return []byte(`"pending"`), nil
+	}
+	return json.Marshal(mediaInfo(mi))
+}
+
+// IsFile returns true if this entry is a file which has not been deleted.
+func (e *Entry) IsFile() bool {
+	return !e.IsDir && !e.IsDeleted
+}
+
+// SizeBytes returns the size of the file in bytes.
+func (e *Entry) SizeBytes() int64 {
+	return e.Bytes
+}
+
+// ErrNoTimestamp is the error returned when a timestamp was not set by the server.
+var ErrNoTimestamp = errors.New("no timestamp")
+
+// ModifiedTime returns the date of last modification of this entry.
+// An error wrapping ErrNoTimestamp is returned when the date was not sent.
+func (e *Entry) ModifiedTime() (time.Time, error) {
+	return entryTime("modified", e.Modified)
+}
+
+// ClientMtimeTime returns the modification time set by the client when this entry was added.
+// An error wrapping ErrNoTimestamp is returned when the date was not sent.
+func (e *Entry) ClientMtimeTime() (time.Time, error) {
+	return entryTime("client_mtime", e.ClientMtime)
+}
+
+func entryTime(field string, dbt DBTime) (time.Time, error) {
+	t := time.Time(dbt)
+	if t.IsZero() {
+		return time.Time{}, fmt.Errorf("%s: %w", field, ErrNoTimestamp)
+	}
+	return t, nil
 }
 
 // Link for sharing a file.
 type Link struct {
-	Expires DBTime `json:"expires"` // Expiration date of this link.
-	URL     string `json:"url"`     // URL to share.
+	Expires    DBTime `json:"expires"`              // Expiration date of this link.
+	URL        string `json:"url"`                  // URL to share.
+	Visibility string `json:"visibility,omitempty"` // Who can access the link (public, team_only, password...) when sent by the server.
+}
+
+// ExpiresTime returns the expiration date of the link, the zero time if not set.
+// The date is already decoded with the response so no error is ever returned,
+// the signature matches CopyRef.ExpiresTime.
+func (l *Link) ExpiresTime() (time.Time, error) {
+	return time.Time(l.Expires), nil
 }
 
 // User represents a Dropbox user.
@@ -215,31 +441,219 @@ type SharedFolder struct {
 	Membership       []SharedFolderMember `json:"membership"`
 }
 
+// RetryPolicy describes how requests failing with a transient error (HTTP 429 or 5xx) are retried.
+// Requests with a body are only retried when the body can be rewound (see FilesPut).
+// A *RateLimitError is returned if the server still replies 429 after the last attempt.
+type RetryPolicy struct {
+	MaxAttempts int           // Maximum number of attempts, retries are disabled when lower than 2.
+	BaseDelay   time.Duration // Delay before the first retry, doubled after each attempt.
+	MaxDelay    time.Duration // Maximum delay between two attempts, unlimited if 0.
+}
+
+// delay returns the time to wait before the given retry attempt (starting at 1).
+// The delay requested by the server in the Retry-After header takes precedence if longer.
+func (rp *RetryPolicy) delay(attempt int, response *http.Response) time.Duration {
+	var d time.Duration
+
+	d = rp.BaseDelay << uint(attempt-1)
+	if rp.MaxDelay > 0 && d > rp.MaxDelay {
+		d = rp.MaxDelay
+	}
+	if ra := retryAfter(response); ra > d {
+		d = ra
+	}
+	return d
+}
+
+// retryAfter returns the delay requested by the server in the Retry-After header, 0 if not set.
+func retryAfter(response *http.Response) time.Duration {
+	if secs, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return 0
+}
+
+// RateLimitError is the error returned when the server replies 429 because too many requests were sent.
+type RateLimitError struct {
+	RetryAfter time.Duration // Delay to wait before sending a new request, 0 if not given by the server.
+}
+
+// Error satisfy the error interface.
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter == 0 {
+		return "too many requests"
+	}
+	return fmt.Sprintf("too many requests, retry after %s", e.RetryAfter)
+}
+
+func newRateLimitError(response *http.Response) *RateLimitError {
+	return &RateLimitError{RetryAfter: retryAfter(response)}
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// RateLimiter limits the rate of the requests sent to the API, *rate.Limiter from golang.org/x/time/rate implements it.
+// Wait is called before each attempt, including the retries made according to RetryPolicy,
+// so a request retried after a 429 also consumes a token.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// NamespacePathRoot returns the value of Dropbox.PathRoot to access the files of the namespace nsID,
+// like a team space, with paths relative to this namespace.
+func NamespacePathRoot(nsID string) string {
+	b, _ := json.Marshal(struct {
+		Tag         string `json:".tag"`
+		NamespaceID string `json:"namespace_id"`
+	}{"namespace_id", nsID})
+	return string(b)
+}
+
+// Root is the root of the paths given to the API.
+type Root string
+
+// Roots accepted by SetRoot, the constants are untyped so they can also be assigned to Dropbox.RootDirectory.
+const (
+	RootAuto    = "auto"    // Root of the application, depends on its access type.
+	RootDropbox = "dropbox" // Root of the whole Dropbox for applications with a full access.
+	RootSandbox = "sandbox" // Folder of the application for applications with an app folder access.
+)
+
+// valid returns true if r is a root known by the API.
+func (r Root) valid() bool {
+	switch r {
+	case RootAuto, RootDropbox, RootSandbox:
+		return true
+	}
+	return false
+}
+
 // Dropbox client.
 type Dropbox struct {
-	RootDirectory string // dropbox or sandbox.
-	Locale        string // Locale sent to the API to translate/format messages.
-	APIURL        string // Normal API URL.
-	APIContentURL string // URL for transferring files.
-	APINotifyURL  string // URL for realtime notification.
-	config        *oauth2.Config
-	token         *oauth2.Token
-	ctx           context.Context
+	RootDirectory          string        // auto, dropbox or sandbox, see SetRoot.
+	Locale                 string        // Locale sent to the API to translate/format messages.
+	APIURL                 string        // Normal API URL.
+	APIContentURL          string        // URL for transferring files.
+	APINotifyURL           string        // URL for realtime notification.
+	RetryPolicy            RetryPolicy   // Retry policy for transient errors, disabled by default.
+	HTTPClient             *http.Client  // Client used to send requests, http.DefaultClient if nil.
+	VerifyUploads          bool          // Compare the content hash of uploaded files when sent by the server.
+	BatchConcurrency       int           // Number of operations run in parallel by batch methods, DefaultBatchConcurrency if 0.
+	CoalesceDeletes        bool          // DeleteBatch removes a folder at once when all its children are listed, see DeleteBatch.
+	WalkLimit              int           // Number of directory listings requested in parallel by Walk, 1 if 0.
+	DefaultUploadChunkSize int           // Chunk size used by chunked uploads when none is given.
+	MaxGetFileSize         int64         // Maximum size of a file read by GetFile, DefaultMaxGetFileSize if 0.
+	MaxSinglePutSize       int64         // Maximum size of a file sent by FilesPut, MaxPutFileSize if 0. Dropbox still rejects what exceeds its own limit.
+	RateLimit              RateLimiter   // Limits the rate of the requests sent by this client, unlimited if nil.
+	PathRoot               string        // Value of the Dropbox-API-Path-Root header to access a team space, see NamespacePathRoot.
+	Timeout                time.Duration // Time limit of a request including reading the reply, no limit if 0. Long polls use their own timeout plus PollTimeoutMargin.
+	Warnf                  WarnFunc      // Called with the non fatal anomalies found in the replies, ignored if nil.
+	DisableCompression     bool          // Ask for uncompressed downloads so that their size is known, see Download.
+	StrictDelta            bool          // Fail Delta on a malformed entry instead of skipping it and reporting it to Warnf.
+	StrictJSON             bool          // Reject the replies of the version 1 API having fields unknown to this package, for development.
+	ReportConflicts        bool          // Uploads saved as a conflicted copy return a ConflictError with the entry of the copy.
+	Trace                  TraceFunc     // Called after each request sent to the API, the access token is redacted.
+	config                 *oauth2.Config
+	token                  *oauth2.Token
+	tokenLock              sync.Mutex
+	ctx                    context.Context
+	api                    primitives // Implementation used by the helpers, db itself if nil.
 }
 
-// NewDropbox returns a new Dropbox configured.
+// NewDropbox returns a new Dropbox configured with the default values.
 func NewDropbox() *Dropbox {
-	db := &Dropbox{
-		RootDirectory: "auto", // auto (recommended), dropbox or sandbox.
-		Locale:        "en",
-		APIURL:        "https://api.dropbox.com/1",
-		APIContentURL: "https://api-content.dropbox.com/1",
-		APINotifyURL:  "https://api-notify.dropbox.com/1",
-		ctx:           oauth2.NoContext,
+	return &Dropbox{
+		RootDirectory:          RootAuto, // auto (recommended), dropbox or sandbox.
+		Locale:                 "en",
+		APIURL:                 "https://api.dropbox.com/1",
+		APIContentURL:          "https://api-content.dropbox.com/1",
+		APINotifyURL:           "https://api-notify.dropbox.com/1",
+		DefaultUploadChunkSize: DefaultChunkSize,
+		MaxSinglePutSize:       MaxPutFileSize,
+		ctx:                    oauth2.NoContext,
+	}
+}
+
+// NewDropboxWithOptions returns a new Dropbox configured with the default values then with opts in order.
+// It returns an error if an option is invalid, like an unknown root or a malformed locale.
+func NewDropboxWithOptions(opts ...Option) (*Dropbox, error) {
+	db := NewDropbox()
+	if err := db.apply(opts); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// base returns the implementation of the primitives used by the helpers: the DropboxV2 embedding db if any, db otherwise.
+func (db *Dropbox) base() primitives {
+	if db.api != nil {
+		return db.api
 	}
 	return db
 }
 
+// uploadChunked uploads the data read from input with the chunked upload API, see UploadByChunk.
+func (db *Dropbox) uploadChunked(input io.ReadCloser, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	return db.UploadByChunk(input, 0, dst, overwrite, parentRev)
+}
+
+// WarnFunc receives the non fatal anomalies found by a client, its arguments are handled like fmt.Printf.
+type WarnFunc func(format string, v ...interface{})
+
+// warnf reports a non fatal anomaly to Warnf if set.
+func (db *Dropbox) warnf(format string, v ...interface{}) {
+	if db.Warnf != nil {
+		db.Warnf(format, v...)
+	}
+}
+
+// SetRoot sets the root of the paths, an error is returned if root is unknown.
+func (db *Dropbox) SetRoot(root Root) error {
+	if !root.valid() {
+		return fmt.Errorf("unknown root '%s' must be auto, dropbox or sandbox", root)
+	}
+	db.RootDirectory = string(root)
+	return nil
+}
+
+// localeRegexp matches the BCP 47 language tags like en, pt-BR or zh_TW.
+var localeRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$`)
+
+// SetLocale sets the locale used by the API to translate/format messages, like "fr" or "pt-BR".
+// An error is returned if locale is not a well formed language tag, the Locale field can still be set directly.
+func (db *Dropbox) SetLocale(locale string) error {
+	if !localeRegexp.MatchString(locale) {
+		return fmt.Errorf("invalid locale '%s' must be a language tag like en or pt-BR", locale)
+	}
+	db.Locale = locale
+	return nil
+}
+
+// SetBaseURLs sets the URLs of the three endpoints of the API at once: api for the normal requests,
+// content for the transfer of files and notify for the long polls. Trailing slashes are removed.
+// An error is returned and nothing is changed if one of them is not an absolute URL.
+func (db *Dropbox) SetBaseURLs(api, content, notify string) error {
+	urls := []string{api, content, notify}
+	for i, rawurl := range urls {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return fmt.Errorf("invalid base URL '%s': %w", rawurl, err)
+		}
+		if !u.IsAbs() || len(u.Host) == 0 {
+			return fmt.Errorf("invalid base URL '%s': not an absolute URL", rawurl)
+		}
+		urls[i] = strings.TrimRight(rawurl, "/")
+	}
+	db.APIURL, db.APIContentURL, db.APINotifyURL = urls[0], urls[1], urls[2]
+	return nil
+}
+
 // SetAppInfo sets the clientid (app_key) and clientsecret (app_secret).
 // You have to register an application on https://www.dropbox.com/developers/apps.
 func (db *Dropbox) SetAppInfo(clientid, clientsecret string) error {
@@ -257,9 +671,62 @@ func (db *Dropbox) SetAppInfo(clientid, clientsecret string) error {
 
 // SetAccessToken sets access token to avoid calling Auth method.
 func (db *Dropbox) SetAccessToken(accesstoken string) {
+	db.tokenLock.Lock()
+	defer db.tokenLock.Unlock()
 	db.token = &oauth2.Token{AccessToken: accesstoken}
 }
 
+// Token returns a copy of the OAuth token including the refresh token and expiry if any.
+func (db *Dropbox) Token() *oauth2.Token {
+	db.tokenLock.Lock()
+	defer db.tokenLock.Unlock()
+	if db.token == nil {
+		return nil
+	}
+	token := *db.token
+	return &token
+}
+
+// SetToken sets the OAuth token to avoid calling Auth method.
+func (db *Dropbox) SetToken(token *oauth2.Token) {
+	db.tokenLock.Lock()
+	defer db.tokenLock.Unlock()
+	db.token = token
+}
+
+// SaveToken writes the OAuth token encoded in JSON to w.
+func (db *Dropbox) SaveToken(w io.Writer) error {
+	token := db.Token()
+	if token == nil {
+		return ErrNotAuth
+	}
+	return json.NewEncoder(w).Encode(token)
+}
+
+// LoadToken reads an OAuth token encoded in JSON by SaveToken from r.
+func (db *Dropbox) LoadToken(r io.Reader) error {
+	var token oauth2.Token
+
+	if err := json.NewDecoder(r).Decode(&token); err != nil {
+		return err
+	}
+	db.SetToken(&token)
+	return nil
+}
+
+// SetRefreshToken sets the refresh token used to get a new access token when the current one has expired.
+func (db *Dropbox) SetRefreshToken(refresh string) {
+	var token oauth2.Token
+
+	db.tokenLock.Lock()
+	defer db.tokenLock.Unlock()
+	if db.token != nil {
+		token = *db.token
+	}
+	token.RefreshToken = refresh
+	db.token = &token
+}
+
 // SetContext allow to set a custom context.
 func (db *Dropbox) SetContext(ctx context.Context) {
 	db.ctx = ctx
@@ -267,6 +734,11 @@ func (db *Dropbox) SetContext(ctx context.Context) {
 
 // AccessToken returns the OAuth access token.
 func (db *Dropbox) AccessToken() string {
+	db.tokenLock.Lock()
+	defer db.tokenLock.Unlock()
+	if db.token == nil {
+		return ""
+	}
 	return db.token.AccessToken
 }
 
@@ -276,7 +748,55 @@ func (db *Dropbox) SetRedirectURL(url string) {
 }
 
 func (db *Dropbox) client() *http.Client {
-	return db.config.Client(db.ctx, db.token)
+	var client http.Client
+
+	db.tokenLock.Lock()
+	token := db.token
+	db.tokenLock.Unlock()
+	if db.HTTPClient == nil {
+		rv := db.config.Client(db.ctx, token)
+		if db.Timeout > 0 {
+			rv.Timeout = db.Timeout
+		}
+		return rv
+	}
+	client = *db.HTTPClient
+	client.Transport = &oauth2.Transport{
+		Source: db.config.TokenSource(db.ctx, token),
+		Base:   db.HTTPClient.Transport,
+	}
+	if db.Timeout > 0 {
+		client.Timeout = db.Timeout
+	}
+	return &client
+}
+
+// canRefresh returns true if the access token can be refreshed.
+func (db *Dropbox) canRefresh() bool {
+	db.tokenLock.Lock()
+	defer db.tokenLock.Unlock()
+	return db.token != nil && len(db.token.RefreshToken) != 0
+}
+
+// refreshToken gets a new access token using the refresh token.
+func (db *Dropbox) refreshToken() error {
+	db.tokenLock.Lock()
+	defer db.tokenLock.Unlock()
+
+	t, err := db.config.TokenSource(db.oauthContext(), &oauth2.Token{RefreshToken: db.token.RefreshToken}).Token()
+	if err != nil {
+		return err
+	}
+	db.token = t
+	return nil
+}
+
+// notifyClient returns the client used for requests which do not need authentication.
+func (db *Dropbox) notifyClient() *http.Client {
+	if db.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return db.HTTPClient
 }
 
 // Auth displays the URL to authorize this application to connect to your account.
@@ -284,23 +804,42 @@ func (db *Dropbox) Auth() error {
 	var code string
 
 	fmt.Printf("Please visit:\n%s\nEnter the code: ",
-		db.config.AuthCodeURL(""))
+		db.AuthCodeURL(""))
 	fmt.Scanln(&code)
-	return db.AuthCode(code)
+	return db.ExchangeCode(code)
 }
 
-// AuthCode gets the token associated with the given code.
-func (db *Dropbox) AuthCode(code string) error {
-	t, err := db.config.Exchange(oauth2.NoContext, code)
+// AuthCodeURL returns the URL to visit to authorize this application to connect to an account.
+// state is sent back to the redirect URL and should be used to protect against CSRF.
+func (db *Dropbox) AuthCodeURL(state string) string {
+	return db.config.AuthCodeURL(state)
+}
+
+// ExchangeCode gets the token associated with the code given after visiting AuthCodeURL.
+func (db *Dropbox) ExchangeCode(code string) error {
+	t, err := db.config.Exchange(db.oauthContext(), code)
 	if err != nil {
 		return err
 	}
 
-	db.token = t
-	db.token.TokenType = "Bearer"
+	t.TokenType = "Bearer"
+	db.SetToken(t)
 	return nil
 }
 
+// AuthCode gets the token associated with the given code.
+func (db *Dropbox) AuthCode(code string) error {
+	return db.ExchangeCode(code)
+}
+
+// oauthContext returns the context used for requests to the OAuth endpoints.
+func (db *Dropbox) oauthContext() context.Context {
+	if db.HTTPClient == nil {
+		return db.ctx
+	}
+	return context.WithValue(db.ctx, oauth2.HTTPClient, db.HTTPClient)
+}
+
 // Error - all errors generated by HTTP transactions are of this type.
 // Other error may be passed on from library functions though.
 type Error struct {
@@ -326,6 +865,46 @@ func newErrorf(StatusCode int, Text string, Parameters ...interface{}) *Error {
 	return newError(StatusCode, fmt.Sprintf(Text, Parameters...))
 }
 
+// APIError represents an error described by the Dropbox API in the body of a reply.
+type APIError struct {
+	StatusCode int    // HTTP status code.
+	Reason     string // Description of this error.
+	Param      string // Name of the offending parameter if any.
+}
+
+// Error satisfy the error interface.
+func (e *APIError) Error() string {
+	if len(e.Param) != 0 {
+		return fmt.Sprintf("%s: %s", e.Param, e.Reason)
+	}
+	return e.Reason
+}
+
+// Is reports whether this error matches ErrNotAuth (401), os.ErrNotExist (404 or a not_found conflict),
+// ErrTargetExists (400 or 403 with a reason containing "already exists", or a conflict reason
+// containing "/conflict" like "to/conflict/file") or ErrInsufficientStorage (507).
+func (e *APIError) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return target == ErrNotAuth
+	case http.StatusNotFound:
+		return target == os.ErrNotExist
+	case http.StatusBadRequest, http.StatusForbidden:
+		return target == ErrTargetExists && strings.Contains(e.Reason, "already exists")
+	case http.StatusConflict:
+		return (target == os.ErrNotExist && strings.Contains(e.Reason, "/not_found")) ||
+			(target == ErrTargetExists && strings.Contains(e.Reason, "/conflict"))
+	case http.StatusInsufficientStorage:
+		return target == ErrInsufficientStorage
+	}
+	return false
+}
+
+// isSuccess returns true if code is a 2xx HTTP status code.
+func isSuccess(code int) bool {
+	return code >= 200 && code < 300
+}
+
 func getResponse(r *http.Response) ([]byte, error) {
 	var e requestError
 	var b []byte
@@ -334,119 +913,453 @@ func getResponse(r *http.Response) ([]byte, error) {
 	if b, err = ioutil.ReadAll(r.Body); err != nil {
 		return nil, err
 	}
-	if r.StatusCode == http.StatusOK {
+	if isSuccess(r.StatusCode) {
 		return b, nil
 	}
+	if r.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
+	if r.StatusCode == http.StatusTooManyRequests {
+		return nil, newRateLimitError(r)
+	}
 	if err = json.Unmarshal(b, &e); err == nil {
 		switch v := e.Error.(type) {
 		case string:
-			return nil, newErrorf(r.StatusCode, "%s", v)
+			return nil, &APIError{StatusCode: r.StatusCode, Reason: v}
 		case map[string]interface{}:
 			for param, reason := range v {
 				if reasonstr, ok := reason.(string); ok {
-					return nil, newErrorf(r.StatusCode, "%s: %s", param, reasonstr)
+					return nil, &APIError{StatusCode: r.StatusCode, Reason: reasonstr, Param: param}
 				}
 			}
-			return nil, newErrorf(r.StatusCode, "wrong parameter")
+			return nil, &APIError{StatusCode: r.StatusCode, Reason: "wrong parameter"}
 		}
 	}
+	switch r.StatusCode {
+	case http.StatusUnauthorized:
+		return nil, ErrNotAuth
+	case http.StatusNotFound:
+		return nil, os.ErrNotExist
+	case http.StatusInsufficientStorage:
+		return nil, ErrInsufficientStorage
+	}
 	return nil, newErrorf(r.StatusCode, "unexpected HTTP status code %d", r.StatusCode)
 }
 
-// urlEncode encodes s for url
-func urlEncode(s string) string {
-	// Would like to call url.escape(value, encodePath) here
-	encoded := url.QueryEscape(s)
-	encoded = strings.Replace(encoded, "+", "%20", -1)
-	return encoded
-}
-
-// CommitChunkedUpload ends the chunked upload by giving a name to the UploadID.
-func (db *Dropbox) CommitChunkedUpload(uploadid, dst string, overwrite bool, parentRev string) (*Entry, error) {
-	var err error
-	var rawurl string
+// do sends the request and retries it according to the RetryPolicy on transient errors.
+// If the server replies 401 and a refresh token is available, the access token is refreshed and the request is sent once more.
+// A request with a body is only retried when GetBody is set to rewind it.
+func (db *Dropbox) do(request *http.Request) (*http.Response, error) {
 	var response *http.Response
-	var params *url.Values
-	var body []byte
-	var rv Entry
+	var err error
+	var refreshed bool
 
-	if dst[0] == '/' {
-		dst = dst[1:]
+	if len(db.PathRoot) != 0 {
+		request.Header.Set("Dropbox-API-Path-Root", db.PathRoot)
+	}
+	for attempt := 1; ; {
+		if db.RateLimit != nil {
+			if err = db.RateLimit.Wait(db.ctx); err != nil {
+				return nil, err
+			}
+		}
+		start := time.Now()
+		response, err = db.client().Do(request)
+		db.trace(request, response, err, start)
+		if err != nil {
+			return nil, err
+		}
+		if request.Body != nil && request.GetBody == nil {
+			return response, nil
+		}
+		switch {
+		case response.StatusCode == http.StatusUnauthorized && !refreshed && db.canRefresh():
+			response.Body.Close()
+			refreshed = true
+			if err = db.refreshToken(); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrNotAuth, err)
+			}
+		case isRetryableStatus(response.StatusCode) && attempt < db.RetryPolicy.MaxAttempts:
+			response.Body.Close()
+			select {
+			case <-time.After(db.RetryPolicy.delay(attempt, response)):
+			case <-db.ctx.Done():
+				return nil, db.ctx.Err()
+			}
+			attempt++
+		default:
+			return response, nil
+		}
+		if request.GetBody != nil {
+			if request.Body, err = request.GetBody(); err != nil {
+				return nil, err
+			}
+		}
 	}
+}
 
-	params = &url.Values{
-		"locale":    {db.Locale},
-		"upload_id": {uploadid},
-		"overwrite": {strconv.FormatBool(overwrite)},
+// RequestInfo describes a request sent to the API, it is given to Dropbox.Trace.
+type RequestInfo struct {
+	Method     string        // HTTP method.
+	URL        string        // URL with the access_token parameter redacted.
+	Header     http.Header   // Headers with the Authorization header redacted.
+	StatusCode int           // HTTP status code of the reply, 0 if it failed.
+	Duration   time.Duration // Time until the headers of the reply were received.
+	Err        error         // Error if the request failed.
+}
+
+// TraceFunc receives the information about each request sent by a client.
+type TraceFunc func(RequestInfo)
+
+// redacted replaces secrets in traces.
+const redacted = "REDACTED"
+
+// trace gives the information about the request to Trace if set.
+func (db *Dropbox) trace(request *http.Request, response *http.Response, err error, start time.Time) {
+	if db.Trace == nil {
+		return
 	}
-	if len(parentRev) != 0 {
-		params.Set("parent_rev", parentRev)
+	info := RequestInfo{Method: request.Method, Header: request.Header.Clone(), Duration: time.Since(start), Err: err}
+	if len(info.Header.Get("Authorization")) != 0 {
+		info.Header.Set("Authorization", redacted)
 	}
-	rawurl = fmt.Sprintf("%s/commit_chunked_upload/%s/%s?%s", db.APIContentURL, db.RootDirectory, urlEncode(dst), params.Encode())
+	u := *request.URL
+	if q := u.Query(); len(q.Get("access_token")) != 0 {
+		q.Set("access_token", redacted)
+		u.RawQuery = q.Encode()
+	}
+	info.URL = u.String()
+	if response != nil {
+		info.StatusCode = response.StatusCode
+	}
+	db.Trace(info)
+}
 
-	if response, err = db.client().Post(rawurl, "", nil); err != nil {
-		return nil, err
+// setRequestBody sets the size bytes read from input as the body of the request.
+// Sending the request fails with ErrSizeMismatch if input does not yield exactly size bytes.
+// The body can be rewound to retry the request only when input implements io.Seeker.
+// If h is not nil, it is fed with the data sent and reset when the body is rewound.
+// input is not closed when the request is sent.
+func setRequestBody(request *http.Request, input io.Reader, size int64, progress ProgressFunc, h hash.Hash) {
+	body := func() io.ReadCloser {
+		var r io.Reader = input
+
+		if h != nil {
+			h.Reset()
+			r = io.TeeReader(input, h)
+		}
+		return newProgressReader(ioutil.NopCloser(&uploadSource{r: r, size: size}), size, progress)
 	}
-	defer response.Body.Close()
-	if body, err = getResponse(response); err != nil {
-		return nil, err
+
+	request.ContentLength = size
+	request.Body = body()
+	if seeker, ok := input.(io.Seeker); ok {
+		if start, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			request.GetBody = func() (io.ReadCloser, error) {
+				if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+					return nil, err
+				}
+				return body(), nil
+			}
+		}
 	}
-	err = json.Unmarshal(body, &rv)
-	return &rv, err
 }
 
-// ChunkedUpload sends a chunk with a maximum size of chunksize, if there is no session a new one is created.
-func (db *Dropbox) ChunkedUpload(session *ChunkUploadResponse, input io.ReadCloser, chunksize int) (*ChunkUploadResponse, error) {
+// setRequestBodyFunc is like setRequestBody but gets the data from a new reader returned by open for each attempt,
+// the request can then always be retried. The readers are closed by the HTTP client once sent.
+func setRequestBodyFunc(request *http.Request, open func() (io.ReadCloser, error), size int64, progress ProgressFunc, h hash.Hash) error {
+	var err error
+
+	body := func() (io.ReadCloser, error) {
+		input, err := open()
+		if err != nil {
+			return nil, err
+		}
+		var r io.Reader = input
+		if h != nil {
+			h.Reset()
+			r = io.TeeReader(input, h)
+		}
+		return newProgressReader(struct {
+			io.Reader
+			io.Closer
+		}{&uploadSource{r: r, size: size}, input}, size, progress), nil
+	}
+
+	request.ContentLength = size
+	if request.Body, err = body(); err != nil {
+		return err
+	}
+	request.GetBody = body
+	return nil
+}
+
+// verifyContentHash checks the content hash of the entry against h when VerifyUploads is set.
+// The check is skipped when the server did not send the content hash.
+func (db *Dropbox) verifyContentHash(entry *Entry, h hash.Hash) error {
+	if h == nil || len(entry.ContentHash) == 0 {
+		return nil
+	}
+	if local := hex.EncodeToString(h.Sum(nil)); local != entry.ContentHash {
+		return fmt.Errorf("%w: local %s remote %s", ErrContentHashMismatch, local, entry.ContentHash)
+	}
+	return nil
+}
+
+// checkUploadConflict returns a ConflictError when the upload to dst was rejected with a conflict (err)
+// or, if ReportConflicts is set, when the server saved the data as a conflicted copy (entry).
+func (db *Dropbox) checkUploadConflict(dst string, entry *Entry, err error) (*Entry, error) {
+	var ae *APIError
+
+	dst = "/" + cleanPath(dst)
+	if errors.As(err, &ae) && ae.StatusCode == http.StatusConflict && strings.Contains(strings.ToLower(ae.Reason), "conflict") {
+		return nil, &ConflictError{Path: dst, Err: err}
+	}
+	if err == nil && db.ReportConflicts && isConflictCopy(dst, entry) {
+		return entry, &ConflictError{Path: dst, CopyPath: entry.Path}
+	}
+	return entry, err
+}
+
+// isConflictCopy returns true if entry was saved by an upload to dst under another path.
+func isConflictCopy(dst string, entry *Entry) bool {
+	return !strings.EqualFold(cleanPath(entry.Path), cleanPath(dst))
+}
+
+// uploadHasher returns a new content hasher if uploads must be verified, nil otherwise.
+func (db *Dropbox) uploadHasher() hash.Hash {
+	if !db.VerifyUploads {
+		return nil
+	}
+	return newContentHasher()
+}
+
+// escapePath percent-encodes each component of path, the / separators are preserved.
+func escapePath(path string) string {
+	components := strings.Split(path, "/")
+	for i, c := range components {
+		components[i] = url.PathEscape(c)
+	}
+	return strings.Join(components, "/")
+}
+
+// urlEncode encodes s for url
+func urlEncode(s string) string {
+	// Would like to call url.escape(value, encodePath) here
+	encoded := url.QueryEscape(s)
+	encoded = strings.Replace(encoded, "+", "%20", -1)
+	return encoded
+}
+
+// CommitChunkedUpload ends the chunked upload by giving a name to the UploadID.
+func (db *Dropbox) CommitChunkedUpload(uploadid, dst string, overwrite bool, parentRev string) (*Entry, error) {
 	var err error
 	var rawurl string
-	var cur ChunkUploadResponse
+	var request *http.Request
 	var response *http.Response
+	var params *url.Values
 	var body []byte
-	var r *io.LimitedReader
+	var rv Entry
+
+	if err = ValidatePath(dst); err != nil {
+		return nil, err
+	}
+	dst = cleanPath(dst)
+
+	params = &url.Values{
+		"locale":    {db.Locale},
+		"upload_id": {uploadid},
+		"overwrite": {strconv.FormatBool(overwrite)},
+	}
+	if len(parentRev) != 0 {
+		params.Set("parent_rev", parentRev)
+	}
+	rawurl = fmt.Sprintf("%s/commit_chunked_upload/%s/%s?%s", db.APIContentURL, db.RootDirectory, escapePath(dst), params.Encode())
+
+	if request, err = http.NewRequest("POST", rawurl, nil); err != nil {
+		return nil, err
+	}
+	if response, err = db.do(request); err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if body, err = getResponse(response); err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &rv)
+	return &rv, err
+}
+
+// maxSinglePutSize returns the maximum size of a file sent in a single request.
+func (db *Dropbox) maxSinglePutSize() int64 {
+	if db.MaxSinglePutSize <= 0 {
+		return MaxPutFileSize
+	}
+	return db.MaxSinglePutSize
+}
 
+// chunkSize returns the chunk size to use for a chunked upload.
+// DefaultUploadChunkSize (or DefaultChunkSize if not set) is used when chunksize is not positive,
+// the result is then clamped to MaxPutFileSize.
+func (db *Dropbox) chunkSize(chunksize int) int {
+	if chunksize <= 0 {
+		chunksize = db.DefaultUploadChunkSize
+	}
 	if chunksize <= 0 {
 		chunksize = DefaultChunkSize
 	} else if chunksize > MaxPutFileSize {
 		chunksize = MaxPutFileSize
 	}
+	return chunksize
+}
+
+// ChunkedUpload sends a chunk with a maximum size of chunksize, if there is no session a new one is created.
+// If chunksize is not positive DefaultUploadChunkSize is used, it is limited to MaxPutFileSize.
+// ErrUploadComplete is returned with the session when input is exhausted, any other error means the chunk was not sent.
+// Previous versions returned io.EOF in this case, callers comparing err == io.EOF must use errors.Is(err, io.EOF)
+// or compare with ErrUploadComplete.
+func (db *Dropbox) ChunkedUpload(session *ChunkUploadResponse, input io.ReadCloser, chunksize int) (*ChunkUploadResponse, error) {
+	var err error
+	var rawurl string
+	var cur ChunkUploadResponse
+	var request *http.Request
+	var response *http.Response
+	var body []byte
+	var r *io.LimitedReader
+	var offset, sent int64
+
+	chunksize = db.chunkSize(chunksize)
 
 	if session != nil {
+		offset = session.Offset
 		rawurl = fmt.Sprintf("%s/chunked_upload?upload_id=%s&offset=%d", db.APIContentURL, session.UploadID, session.Offset)
 	} else {
 		rawurl = fmt.Sprintf("%s/chunked_upload", db.APIContentURL)
 	}
 	r = &io.LimitedReader{R: input, N: int64(chunksize)}
 
-	if response, err = db.client().Post(rawurl, "application/octet-stream", r); err != nil {
+	if request, err = http.NewRequest("POST", rawurl, r); err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	if response, err = db.do(request); err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 	if body, err = getResponse(response); err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(body, &cur)
+	if err = json.Unmarshal(body, &cur); err != nil {
+		return nil, err
+	}
+	sent = int64(chunksize) - r.N
+	if cur.Offset != offset+sent {
+		return nil, fmt.Errorf("chunked upload offset mismatch: sent %d bytes from offset %d but server is at offset %d", sent, offset, cur.Offset)
+	}
 	if r.N != 0 {
-		err = io.EOF
+		err = ErrUploadComplete
 	}
 	return &cur, err
 }
 
 // UploadByChunk uploads data from the input reader to the dst path on Dropbox by sending chunks of chunksize.
+// If chunksize is not positive DefaultUploadChunkSize is used, it is limited to MaxPutFileSize.
+// The next chunk is read from input while the current one is sent, up to three chunks are kept in memory:
+// up to about 450MB when chunksize is limited to MaxPutFileSize.
 func (db *Dropbox) UploadByChunk(input io.ReadCloser, chunksize int, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	return db.UploadByChunkProgress(input, chunksize, dst, overwrite, parentRev, nil)
+}
+
+// UploadByChunkProgress is like UploadByChunk but calls progress with the offset reached after each chunk.
+// The total size is not known and is always reported as -1.
+func (db *Dropbox) UploadByChunkProgress(input io.ReadCloser, chunksize int, dst string, overwrite bool, parentRev string, progress ProgressFunc) (*Entry, error) {
+	return db.uploadChunks(nil, input, chunksize, dst, overwrite, parentRev, progress, db.uploadHasher())
+}
+
+// ResumeChunkedUpload resumes an upload interrupted after session was returned by ChunkedUpload.
+// input is positioned at session.Offset, the remaining data is sent by chunks of chunksize and the upload is committed.
+// An upload can only be resumed if the session returned by each call to ChunkedUpload was saved and has not expired.
+func (db *Dropbox) ResumeChunkedUpload(session *ChunkUploadResponse, input io.ReadSeeker, chunksize int, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	var err error
+	var h hash.Hash
+
+	if h = db.uploadHasher(); h != nil {
+		if _, err = input.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err = io.CopyN(h, input, session.Offset); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = input.Seek(session.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return db.uploadChunks(session, ioutil.NopCloser(input), chunksize, dst, overwrite, parentRev, nil, h)
+}
+
+// uploadChunks sends the data read from input by chunks after the session cur (nil to start a new upload) and commits it.
+// The next chunk is read from input while the current one is sent.
+// If h is not nil, it is fed with the data sent to verify the content hash of the resulting entry.
+func (db *Dropbox) uploadChunks(cur *ChunkUploadResponse, input io.ReadCloser, chunksize int, dst string, overwrite bool, parentRev string, progress ProgressFunc, h hash.Hash) (*Entry, error) {
 	var err error
-	var cur *ChunkUploadResponse
+	var entry *Entry
+	var r io.Reader = input
 
+	if h != nil {
+		r = io.TeeReader(input, h)
+	}
+	ra := newReadAheadReader(r, db.chunkSize(chunksize))
+	defer ra.stop()
+	input = struct {
+		io.Reader
+		io.Closer
+	}{ra, input}
 	for err == nil {
-		if cur, err = db.ChunkedUpload(cur, input, chunksize); err != nil && err != io.EOF {
+		if cur, err = db.ChunkedUpload(cur, input, chunksize); err != nil && err != ErrUploadComplete {
 			return nil, err
 		}
+		if progress != nil {
+			progress(cur.Offset, -1)
+		}
+	}
+	if entry, err = db.CommitChunkedUpload(cur.UploadID, dst, overwrite, parentRev); err != nil {
+		return db.checkUploadConflict(dst, nil, err)
+	}
+	if err = db.verifyContentHash(entry, h); err != nil {
+		return nil, err
 	}
-	return db.CommitChunkedUpload(cur.UploadID, dst, overwrite, parentRev)
+	return db.checkUploadConflict(dst, entry, nil)
 }
 
 // FilesPut uploads size bytes from the input reader to the dst path on Dropbox.
+// The upload is only retried on transient errors when input implements io.Seeker.
+// It fails with ErrSizeMismatch if input does not yield exactly size bytes, see NewUploadSource to wrap an io.Reader.
+// Files bigger than MaxSinglePutSize are rejected without being sent.
 func (db *Dropbox) FilesPut(input io.ReadCloser, size int64, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	return db.FilesPutProgress(input, size, dst, overwrite, parentRev, nil)
+}
+
+// FilesPutProgress is like FilesPut but calls progress as the data is sent.
+func (db *Dropbox) FilesPutProgress(input io.ReadCloser, size int64, dst string, overwrite bool, parentRev string, progress ProgressFunc) (*Entry, error) {
+	defer input.Close()
+	return db.filesPut(size, dst, overwrite, parentRev, func(request *http.Request, h hash.Hash) error {
+		setRequestBody(request, input, size, progress, h)
+		return nil
+	})
+}
+
+// FilesPutFunc is like FilesPut but calls bodyFn to open the data to send for each attempt,
+// the upload can then be retried on transient errors whatever the source. Each reader returned by bodyFn is closed.
+func (db *Dropbox) FilesPutFunc(bodyFn func() (io.ReadCloser, error), size int64, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	return db.filesPut(size, dst, overwrite, parentRev, func(request *http.Request, h hash.Hash) error {
+		return setRequestBodyFunc(request, bodyFn, size, nil, h)
+	})
+}
+
+// filesPut uploads size bytes to the dst path on Dropbox, the body of the request is set by setBody.
+func (db *Dropbox) filesPut(size int64, dst string, overwrite bool, parentRev string, setBody func(*http.Request, hash.Hash) error) (*Entry, error) {
 	var err error
+	var h hash.Hash
 	var rawurl string
 	var rv Entry
 	var request *http.Request
@@ -454,55 +1367,144 @@ func (db *Dropbox) FilesPut(input io.ReadCloser, size int64, dst string, overwri
 	var params *url.Values
 	var body []byte
 
-	if size > MaxPutFileSize {
-		return nil, fmt.Errorf("could not upload files bigger than 150MB using this method, use UploadByChunk instead")
+	if max := db.maxSinglePutSize(); size > max {
+		return nil, fmt.Errorf("could not upload files bigger than %d bytes using this method, use UploadByChunk instead", max)
 	}
-	if dst[0] == '/' {
-		dst = dst[1:]
+	if err = ValidatePath(dst); err != nil {
+		return nil, err
 	}
+	dst = cleanPath(dst)
 
 	params = &url.Values{"overwrite": {strconv.FormatBool(overwrite)}, "locale": {db.Locale}}
 	if len(parentRev) != 0 {
 		params.Set("parent_rev", parentRev)
 	}
-	rawurl = fmt.Sprintf("%s/files_put/%s/%s?%s", db.APIContentURL, db.RootDirectory, urlEncode(dst), params.Encode())
+	rawurl = fmt.Sprintf("%s/files_put/%s/%s?%s", db.APIContentURL, db.RootDirectory, escapePath(dst), params.Encode())
 
-	if request, err = http.NewRequest("PUT", rawurl, input); err != nil {
+	if request, err = http.NewRequest("PUT", rawurl, nil); err != nil {
 		return nil, err
 	}
-	request.Header.Set("Content-Length", strconv.FormatInt(size, 10))
-	if response, err = db.client().Do(request); err != nil {
+	h = db.uploadHasher()
+	if err = setBody(request, h); err != nil {
+		return nil, err
+	}
+	if response, err = db.do(request); err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 	if body, err = getResponse(response); err != nil {
+		return db.checkUploadConflict(dst, nil, err)
+	}
+	if err = json.Unmarshal(body, &rv); err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(body, &rv)
-	return &rv, err
+	if err = db.verifyContentHash(&rv, h); err != nil {
+		return nil, err
+	}
+	return db.checkUploadConflict(dst, &rv, nil)
+}
+
+// PutBytes uploads data to the dst path on Dropbox.
+func (db *Dropbox) PutBytes(data []byte, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	return db.base().FilesPut(NewUploadSource(bytes.NewReader(data), int64(len(data))), int64(len(data)), dst, overwrite, parentRev)
+}
+
+// PutString uploads the content of s to the dst path on Dropbox.
+func (db *Dropbox) PutString(s, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	return db.PutBytes([]byte(s), dst, overwrite, parentRev)
+}
+
+// Upload uploads size bytes from input to the dst path on Dropbox with FilesPut if size is at most DefaultChunkSize,
+// with UploadByChunk otherwise or if size is negative (unknown).
+// input is closed at the end if it implements io.Closer.
+func (db *Dropbox) Upload(input io.Reader, size int64, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	src := NewUploadSource(input, size)
+	if size >= 0 && size <= DefaultChunkSize {
+		return db.base().FilesPut(src, size, dst, overwrite, parentRev)
+	}
+	return db.base().uploadChunked(src, dst, overwrite, parentRev)
+}
+
+// UploadStream uploads the data read from input until io.EOF to the dst path on Dropbox without knowing its size,
+// it is always sent with the chunked upload API. input is closed at the end if it implements io.Closer.
+func (db *Dropbox) UploadStream(input io.Reader, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	return db.base().uploadChunked(NewUploadSource(input, -1), dst, overwrite, parentRev)
+}
+
+// UploadIfUnchanged uploads size bytes from input to dst like Upload if the file located at dst is still at the
+// revision knownRev, the revision last seen by the caller, or does not exist yet when knownRev is empty.
+// Otherwise the server saves the data as a conflicted copy next to dst and the boolean returned is true,
+// the path of the copy is the one of the entry returned.
+func (db *Dropbox) UploadIfUnchanged(input io.Reader, size int64, dst string, knownRev string) (*Entry, bool, error) {
+	var ce *ConflictError
+
+	entry, err := db.Upload(input, size, dst, false, knownRev)
+	if errors.As(err, &ce) && len(ce.CopyPath) != 0 {
+		return entry, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return entry, isConflictCopy(dst, entry), nil
 }
 
 // UploadFile uploads the file located in the src path on the local disk to the dst path on Dropbox.
+// The file is opened again for each attempt.
 func (db *Dropbox) UploadFile(src, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	var err error
+	var fi os.FileInfo
+
+	if fi, err = os.Stat(src); err != nil {
+		return nil, err
+	}
+	return db.base().FilesPutFunc(func() (io.ReadCloser, error) { return os.Open(src) }, fi.Size(), dst, overwrite, parentRev)
+}
+
+// UploadFileIfChanged is like UploadFile but skips the upload when the file located at dst on Dropbox
+// has the same content hash as the local file, the existing entry is then returned.
+// The boolean returned is true if the file was uploaded.
+func (db *Dropbox) UploadFileIfChanged(src, dst string, overwrite bool, parentRev string) (*Entry, bool, error) {
 	var err error
 	var fd *os.File
-	var fsize int64
+	var fi os.FileInfo
+	var entry *Entry
+	var sum string
 
 	if fd, err = os.Open(src); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer fd.Close()
+	if fi, err = fd.Stat(); err != nil {
+		return nil, false, err
+	}
 
-	if fi, err := fd.Stat(); err == nil {
-		fsize = fi.Size()
-	} else {
-		return nil, err
+	entry, err = db.base().Metadata(dst, false, false, "", "", 0)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
 	}
-	return db.FilesPut(fd, fsize, dst, overwrite, parentRev)
+	if err == nil && !entry.IsDir && !entry.IsDeleted && len(entry.ContentHash) != 0 && entry.Bytes == fi.Size() {
+		if sum, err = ContentHash(fd); err != nil {
+			return nil, false, err
+		}
+		if sum == entry.ContentHash {
+			return entry, false, nil
+		}
+		if _, err = fd.Seek(0, io.SeekStart); err != nil {
+			return nil, false, err
+		}
+	}
+	entry, err = db.base().FilesPut(fd, fi.Size(), dst, overwrite, parentRev)
+	return entry, err == nil, err
 }
 
 // Thumbnails gets a thumbnail for an image.
+// size is one of xs, s (default), m, l, xl, w640h480, w1024h768 or w2048h1536.
 func (db *Dropbox) Thumbnails(src, format, size string) (io.ReadCloser, int64, *Entry, error) {
+	return db.thumbnails(db.ctx, src, format, size)
+}
+
+// thumbnails is like Thumbnails but the request and the reading of the reply are canceled with ctx.
+func (db *Dropbox) thumbnails(ctx context.Context, src, format, size string) (io.ReadCloser, int64, *Entry, error) {
+	var request *http.Request
 	var response *http.Response
 	var rawurl string
 	var err error
@@ -519,20 +1521,22 @@ func (db *Dropbox) Thumbnails(src, format, size string) (io.ReadCloser, int64, *
 	switch size {
 	case "":
 		size = "s"
-	case "xs", "s", "m", "l", "xl":
+	case "xs", "s", "m", "l", "xl", "w640h480", "w1024h768", "w2048h1536":
 		break
 	default:
-		return nil, 0, nil, fmt.Errorf("unsupported size '%s' must be xs, s, m, l or xl", size)
+		return nil, 0, nil, fmt.Errorf("unsupported size '%s' must be xs, s, m, l, xl, w640h480, w1024h768 or w2048h1536", size)
 
 	}
-	if src[0] == '/' {
-		src = src[1:]
+	src = cleanPath(src)
+	rawurl = fmt.Sprintf("%s/thumbnails/%s/%s?format=%s&size=%s", db.APIContentURL, db.RootDirectory, escapePath(src), urlEncode(format), urlEncode(size))
+	if request, err = http.NewRequest("GET", rawurl, nil); err != nil {
+		return nil, 0, nil, err
 	}
-	rawurl = fmt.Sprintf("%s/thumbnails/%s/%s?format=%s&size=%s", db.APIContentURL, db.RootDirectory, urlEncode(src), urlEncode(format), urlEncode(size))
-	if response, err = db.client().Get(rawurl); err != nil {
+	request = request.WithContext(ctx)
+	if response, err = db.do(request); err != nil {
 		return nil, 0, nil, err
 	}
-	if response.StatusCode == http.StatusOK {
+	if isSuccess(response.StatusCode) {
 		json.Unmarshal([]byte(response.Header.Get("x-dropbox-metadata")), &entry)
 		return response.Body, response.ContentLength, &entry, err
 	}
@@ -540,6 +1544,8 @@ func (db *Dropbox) Thumbnails(src, format, size string) (io.ReadCloser, int64, *
 	switch response.StatusCode {
 	case http.StatusNotFound:
 		return nil, 0, nil, os.ErrNotExist
+	case http.StatusTooManyRequests:
+		return nil, 0, nil, newRateLimitError(response)
 	case http.StatusUnsupportedMediaType:
 		return nil, 0, nil, newErrorf(response.StatusCode, "the image located at '%s' cannot be converted to a thumbnail", src)
 	default:
@@ -548,7 +1554,84 @@ func (db *Dropbox) Thumbnails(src, format, size string) (io.ReadCloser, int64, *
 }
 
 // ThumbnailsToFile downloads the file located in the src path on the Dropbox to the dst file on the local disk.
+// dst is removed if the download fails.
 func (db *Dropbox) ThumbnailsToFile(src, dst, format, size string) (*Entry, error) {
+	return db.ThumbnailsToFileContext(db.ctx, src, dst, format, size)
+}
+
+// ThumbnailsToFileContext is like ThumbnailsToFile but the download is canceled with ctx,
+// the partial dst file is then removed and the error of ctx is returned.
+func (db *Dropbox) ThumbnailsToFileContext(ctx context.Context, src, dst, format, size string) (*Entry, error) {
+	var input io.ReadCloser
+	var fd *os.File
+	var err error
+	var entry *Entry
+
+	if fd, err = os.Create(dst); err != nil {
+		return nil, err
+	}
+	if input, _, entry, err = db.thumbnails(ctx, src, format, size); err != nil {
+		return nil, removePartialFile(fd, err)
+	}
+	defer input.Close()
+	if _, err = io.Copy(fd, input); ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = fd.Close()
+	}
+	if err != nil {
+		return nil, removePartialFile(fd, err)
+	}
+	return entry, nil
+}
+
+// removePartialFile closes fd and removes the file of an interrupted download, err is the cause of the interruption.
+// The failure to remove the file is added to err.
+func removePartialFile(fd *os.File, err error) error {
+	fd.Close()
+	if rmErr := os.Remove(fd.Name()); rmErr != nil {
+		return fmt.Errorf("%w (the partial file could not be removed: %v)", err, rmErr)
+	}
+	return err
+}
+
+// Preview gets a PDF preview of a document (.doc, .xls, ...), the specific revision may be given.
+func (db *Dropbox) Preview(src, rev string) (io.ReadCloser, int64, *Entry, error) {
+	var request *http.Request
+	var response *http.Response
+	var rawurl string
+	var err error
+
+	src = cleanPath(src)
+	rawurl = fmt.Sprintf("%s/previews/%s/%s", db.APIContentURL, db.RootDirectory, escapePath(src))
+	if len(rev) != 0 {
+		rawurl += fmt.Sprintf("?rev=%s", urlEncode(rev))
+	}
+	if request, err = http.NewRequest("GET", rawurl, nil); err != nil {
+		return nil, 0, nil, err
+	}
+	if response, err = db.do(request); err != nil {
+		return nil, 0, nil, err
+	}
+	if isSuccess(response.StatusCode) {
+		return response.Body, response.ContentLength, metadataHeader(response), nil
+	}
+	response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusNotFound:
+		return nil, 0, nil, os.ErrNotExist
+	case http.StatusTooManyRequests:
+		return nil, 0, nil, newRateLimitError(response)
+	case http.StatusUnsupportedMediaType:
+		return nil, 0, nil, newErrorf(response.StatusCode, "no preview can be generated for the file located at '%s'", src)
+	default:
+		return nil, 0, nil, newErrorf(response.StatusCode, "unexpected HTTP status code %d", response.StatusCode)
+	}
+}
+
+// PreviewToFile downloads the preview of the file located in the src path on the Dropbox to the dst file on the local disk.
+func (db *Dropbox) PreviewToFile(src, dst, rev string) (*Entry, error) {
 	var input io.ReadCloser
 	var fd *os.File
 	var err error
@@ -559,7 +1642,7 @@ func (db *Dropbox) ThumbnailsToFile(src, dst, format, size string) (*Entry, erro
 	}
 	defer fd.Close()
 
-	if input, _, entry, err = db.Thumbnails(src, format, size); err != nil {
+	if input, _, entry, err = db.Preview(src, rev); err != nil {
 		os.Remove(dst)
 		return nil, err
 	}
@@ -573,40 +1656,146 @@ func (db *Dropbox) ThumbnailsToFile(src, dst, format, size string) (*Entry, erro
 // Download requests the file located at src, the specific revision may be given.
 // offset is used in case the download was interrupted.
 // A io.ReadCloser and the file size is returned.
+// The size is -1 when the reply was compressed and transparently decoded since the decoded size is not known,
+// set DisableCompression to always get it.
 func (db *Dropbox) Download(src, rev string, offset int64) (io.ReadCloser, int64, error) {
+	body, size, _, err := db.DownloadWithMetadata(src, rev, offset)
+	return body, size, err
+}
+
+// DownloadIfChanged downloads the file located at src unless its current revision is localRev.
+// The metadata of the file is always returned, the boolean is false and the io.ReadCloser nil when it did not change.
+func (db *Dropbox) DownloadIfChanged(src, localRev string) (io.ReadCloser, *Entry, bool, error) {
+	var entry *Entry
+	var body io.ReadCloser
+	var err error
+
+	if entry, err = db.base().Metadata(src, false, false, "", "", 0); err != nil {
+		return nil, nil, false, err
+	}
+	if entry.IsDir {
+		return nil, nil, false, fmt.Errorf("'%s' is a directory", cleanPath(src))
+	}
+	if len(localRev) != 0 && entry.Revision == localRev {
+		return nil, entry, false, nil
+	}
+	if body, _, err = db.base().Download(src, entry.Revision, 0); err != nil {
+		return nil, nil, false, err
+	}
+	return body, entry, true, nil
+}
+
+// DownloadWithMetadata is like Download but also returns the metadata of the file sent by the server, nil if absent.
+func (db *Dropbox) DownloadWithMetadata(src, rev string, offset int64) (io.ReadCloser, int64, *Entry, error) {
+	var byteRange string
+
+	if offset != 0 {
+		byteRange = fmt.Sprintf("bytes=%d-", offset)
+	}
+	return db.base().download(src, rev, byteRange)
+}
+
+// DownloadRange requests the bytes from start to end (both included) of the file located at src,
+// the specific revision may be given.
+// If end is negative the file is read until its end, if start is negative the last -start bytes are requested.
+// A io.ReadCloser and the size of the range are returned.
+func (db *Dropbox) DownloadRange(src, rev string, start, end int64) (io.ReadCloser, int64, error) {
+	var byteRange string
+
+	switch {
+	case start < 0:
+		byteRange = fmt.Sprintf("bytes=%d", start)
+	case end < 0:
+		byteRange = fmt.Sprintf("bytes=%d-", start)
+	case end < start:
+		return nil, 0, fmt.Errorf("invalid range %d-%d", start, end)
+	default:
+		byteRange = fmt.Sprintf("bytes=%d-%d", start, end)
+	}
+	body, size, _, err := db.base().download(src, rev, byteRange)
+	return body, size, err
+}
+
+// download requests the file located at src, byteRange is the value of the Range header if not empty.
+func (db *Dropbox) download(src, rev, byteRange string) (io.ReadCloser, int64, *Entry, error) {
 	var request *http.Request
 	var response *http.Response
 	var rawurl string
 	var err error
 
-	if src[0] == '/' {
-		src = src[1:]
-	}
+	src = cleanPath(src)
 
-	rawurl = fmt.Sprintf("%s/files/%s/%s", db.APIContentURL, db.RootDirectory, urlEncode(src))
+	rawurl = fmt.Sprintf("%s/files/%s/%s", db.APIContentURL, db.RootDirectory, escapePath(src))
 	if len(rev) != 0 {
 		rawurl += fmt.Sprintf("?rev=%s", rev)
 	}
 	if request, err = http.NewRequest("GET", rawurl, nil); err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
-	if offset != 0 {
-		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	if len(byteRange) != 0 {
+		request.Header.Set("Range", byteRange)
+	}
+	if db.DisableCompression {
+		// The transport only asks for and decodes gzip when Accept-Encoding is not set.
+		request.Header.Set("Accept-Encoding", "identity")
 	}
 
-	if response, err = db.client().Do(request); err != nil {
-		return nil, 0, err
+	if response, err = db.do(request); err != nil {
+		return nil, 0, nil, err
 	}
-	if response.StatusCode == http.StatusOK || response.StatusCode == http.StatusPartialContent {
-		return response.Body, response.ContentLength, err
+	if isSuccess(response.StatusCode) {
+		return response.Body, response.ContentLength, metadataHeader(response), nil
 	}
 	response.Body.Close()
 	switch response.StatusCode {
 	case http.StatusNotFound:
-		return nil, 0, os.ErrNotExist
+		return nil, 0, nil, os.ErrNotExist
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil, 0, nil, newErrorf(response.StatusCode, "range %s is not satisfiable for the file located at '%s'", strings.TrimPrefix(byteRange, "bytes="), src)
+	case http.StatusTooManyRequests:
+		return nil, 0, nil, newRateLimitError(response)
 	default:
-		return nil, 0, newErrorf(response.StatusCode, "unexpected HTTP status code %d", response.StatusCode)
+		return nil, 0, nil, newErrorf(response.StatusCode, "unexpected HTTP status code %d", response.StatusCode)
+	}
+}
+
+// metadataHeader returns the entry sent in the x-dropbox-metadata header, nil if absent or invalid.
+func metadataHeader(response *http.Response) *Entry {
+	var entry Entry
+
+	if err := json.Unmarshal([]byte(response.Header.Get("x-dropbox-metadata")), &entry); err != nil {
+		return nil
 	}
+	return &entry
+}
+
+// GetFile downloads the file located at src in memory, the specific revision may be given.
+// The content of the file and its metadata (nil if not sent by the server) are returned.
+// An error is returned if the file is bigger than MaxGetFileSize.
+func (db *Dropbox) GetFile(src, rev string) ([]byte, *Entry, error) {
+	var input io.ReadCloser
+	var size, max int64
+	var entry *Entry
+	var data []byte
+	var err error
+
+	if max = db.MaxGetFileSize; max <= 0 {
+		max = DefaultMaxGetFileSize
+	}
+	if input, size, entry, err = db.DownloadWithMetadata(src, rev, 0); err != nil {
+		return nil, nil, err
+	}
+	defer input.Close()
+	if size > max {
+		return nil, nil, fmt.Errorf("file of %d bytes exceeds the maximum size of %d bytes", size, max)
+	}
+	if data, err = ioutil.ReadAll(io.LimitReader(input, max+1)); err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, nil, fmt.Errorf("file exceeds the maximum size of %d bytes", max)
+	}
+	return data, entry, nil
 }
 
 // DownloadToFileResume resumes the download of the file located in the src path on the Dropbox to the dst file on the local disk.
@@ -626,7 +1815,7 @@ func (db *Dropbox) DownloadToFileResume(src, dst, rev string) error {
 	}
 	offset = fi.Size()
 
-	if input, _, err = db.Download(src, rev, offset); err != nil {
+	if input, _, err = db.base().Download(src, rev, offset); err != nil {
 		return err
 	}
 	defer input.Close()
@@ -637,28 +1826,73 @@ func (db *Dropbox) DownloadToFileResume(src, dst, rev string) error {
 // DownloadToFile downloads the file located in the src path on the Dropbox to the dst file on the local disk.
 // If the destination file exists it will be truncated.
 func (db *Dropbox) DownloadToFile(src, dst, rev string) error {
+	return db.DownloadToFileProgress(src, dst, rev, nil)
+}
+
+// DownloadToFileProgress is like DownloadToFile but calls progress as the data is received.
+// The total size is reported as -1 when the server does not send the Content-Length.
+func (db *Dropbox) DownloadToFileProgress(src, dst, rev string, progress ProgressFunc) error {
 	var input io.ReadCloser
+	var size int64
 	var fd *os.File
 	var err error
 
 	if fd, err = os.Create(dst); err != nil {
 		return err
 	}
-	defer fd.Close()
-
-	if input, _, err = db.Download(src, rev, 0); err != nil {
+	defer fd.Close()
+
+	if input, size, err = db.base().Download(src, rev, 0); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	input = newProgressReader(input, size, progress)
+	defer input.Close()
+	if _, err = io.Copy(fd, input); err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// DownloadToFileVerified is like DownloadToFile but computes the content hash of the data as it is written
+// and compares it to expectedContentHash, or to the one sent by the server in the metadata if empty.
+// The file is removed and an error matching ErrContentHashMismatch is returned if they differ.
+func (db *Dropbox) DownloadToFileVerified(src, dst, rev, expectedContentHash string) error {
+	var input io.ReadCloser
+	var entry *Entry
+	var fd *os.File
+	var err error
+
+	if fd, err = os.Create(dst); err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if input, _, entry, err = db.DownloadWithMetadata(src, rev, 0); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	defer input.Close()
+	if len(expectedContentHash) == 0 && entry != nil {
+		expectedContentHash = entry.ContentHash
+	}
+	if len(expectedContentHash) == 0 {
+		os.Remove(dst)
+		return fmt.Errorf("no content hash to verify '%s' against", cleanPath(src))
+	}
+	h := newContentHasher()
+	if _, err = io.Copy(fd, io.TeeReader(input, h)); err != nil {
 		os.Remove(dst)
 		return err
 	}
-	defer input.Close()
-	if _, err = io.Copy(fd, input); err != nil {
+	if local := hex.EncodeToString(h.Sum(nil)); local != expectedContentHash {
 		os.Remove(dst)
+		return fmt.Errorf("%w: local %s remote %s", ErrContentHashMismatch, local, expectedContentHash)
 	}
-	return err
+	return nil
 }
 
 func (db *Dropbox) doRequest(method, path string, params *url.Values, receiver interface{}) error {
-	var body []byte
 	var rawurl string
 	var response *http.Response
 	var request *http.Request
@@ -669,20 +1903,37 @@ func (db *Dropbox) doRequest(method, path string, params *url.Values, receiver i
 	} else {
 		params.Set("locale", db.Locale)
 	}
-	rawurl = fmt.Sprintf("%s/%s?%s", db.APIURL, urlEncode(path), params.Encode())
-	fmt.Println(rawurl)
+	rawurl = fmt.Sprintf("%s/%s?%s", db.APIURL, escapePath(path), params.Encode())
 	if request, err = http.NewRequest(method, rawurl, nil); err != nil {
 		return err
 	}
-	if response, err = db.client().Do(request); err != nil {
+	if response, err = db.do(request); err != nil {
 		return err
 	}
 	defer response.Body.Close()
-	if body, err = getResponse(response); err != nil {
+	if !isSuccess(response.StatusCode) {
+		_, err = getResponse(response)
 		return err
 	}
-	err = json.Unmarshal(body, receiver)
-	return err
+	return db.decodeJSON(response.Body, receiver)
+}
+
+// decodeJSON decodes the reply read from body in receiver without buffering it,
+// fields unknown to receiver are an error when StrictJSON is set.
+// Some endpoints reply with an empty body on success, the receiver is then left untouched.
+// The reply is discarded when receiver is nil.
+func (db *Dropbox) decodeJSON(body io.Reader, receiver interface{}) error {
+	if receiver == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(body)
+	if db.StrictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(receiver); err != io.EOF {
+		return err
+	}
+	return nil
 }
 
 // GetAccountInfo gets account information for the user currently authenticated.
@@ -693,47 +1944,230 @@ func (db *Dropbox) GetAccountInfo() (*Account, error) {
 	return &rv, err
 }
 
+// Revoke disables the current access token on the server and forgets it locally.
+// ErrNotAuth is returned if no token is set.
+func (db *Dropbox) Revoke() error {
+	db.tokenLock.Lock()
+	token := db.token
+	db.tokenLock.Unlock()
+	if token == nil || len(token.AccessToken) == 0 {
+		return ErrNotAuth
+	}
+	if err := db.doRequest("POST", "disable_access_token", nil, nil); err != nil {
+		return err
+	}
+	db.tokenLock.Lock()
+	db.token = nil
+	db.tokenLock.Unlock()
+	return nil
+}
+
+// Ping checks that the API can be reached with the current credentials.
+// ErrNotAuth is returned if the access token is not valid.
+func (db *Dropbox) Ping() error {
+	err := db.doRequest("GET", "account/info", nil, nil)
+	if errors.Is(err, ErrNotAuth) {
+		return ErrNotAuth
+	}
+	return err
+}
+
 // Shares shares a file.
 func (db *Dropbox) Shares(path string, shortURL bool) (*Link, error) {
 	var rv Link
 	var params *url.Values
 
 	params = &url.Values{"short_url": {strconv.FormatBool(shortURL)}}
-	act := strings.Join([]string{"shares", db.RootDirectory, path}, "/")
+	act := strings.Join([]string{"shares", db.RootDirectory, cleanPath(path)}, "/")
 	err := db.doRequest("POST", act, params, &rv)
 	return &rv, err
 }
 
+// ShareSettings are the settings of a shared link created by SharesWithSettings.
+type ShareSettings struct {
+	ShortURL   bool      // Return a shortened URL.
+	Visibility string    // public, team_only or password, the default of the account if empty.
+	Password   string    // Password required to access the link when Visibility is password.
+	Expires    time.Time // Expiration date of the link, it never expires if zero.
+}
+
+// SharesWithSettings shares a file with the given settings.
+// Only ShortURL is supported by the version 1 of the API, an error is returned if other settings are given,
+// see DropboxV2.SharesWithSettings.
+func (db *Dropbox) SharesWithSettings(path string, settings ShareSettings) (*Link, error) {
+	if len(settings.Visibility) != 0 || len(settings.Password) != 0 || !settings.Expires.IsZero() {
+		return nil, fmt.Errorf("link visibility, password and expiration are not supported by the version 1 of the API")
+	}
+	return db.Shares(path, settings.ShortURL)
+}
+
 // Media shares a file for streaming (direct access).
+// The endpoint is deprecated, see TemporaryLink.
 func (db *Dropbox) Media(path string) (*Link, error) {
 	var rv Link
 
-	act := strings.Join([]string{"media", db.RootDirectory, path}, "/")
+	act := strings.Join([]string{"media", db.RootDirectory, cleanPath(path)}, "/")
 	err := db.doRequest("POST", act, nil, &rv)
 	return &rv, err
 }
 
+// MediaToFile gets a streaming link for the file located at path and downloads its content to the dst file on the local disk.
+func (db *Dropbox) MediaToFile(path, dst string) error {
+	var link *Link
+	var response *http.Response
+	var fd *os.File
+	var err error
+
+	if link, err = db.Media(path); err != nil {
+		return err
+	}
+	if response, err = db.notifyClient().Get(link.URL); err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if !isSuccess(response.StatusCode) {
+		return newErrorf(response.StatusCode, "unable to fetch the media link of '%s' (it may have expired): HTTP status code %d", path, response.StatusCode)
+	}
+	if fd, err = os.Create(dst); err != nil {
+		return err
+	}
+	defer fd.Close()
+	if _, err = io.Copy(fd, response.Body); err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// DownloadSharedLink requests the content of the file shared with the link, like https://www.dropbox.com/s/xxx/name?dl=0.
+// The links of www.dropbox.com are rewritten to their direct download form (dl=1) and fetched without authentication.
+// The password of protected links is only supported by DropboxV2, an error matching ErrNotSupported is returned if set.
+// A io.ReadCloser and the file size (-1 if unknown) are returned.
+func (db *Dropbox) DownloadSharedLink(link, password string) (io.ReadCloser, int64, error) {
+	var u *url.URL
+	var response *http.Response
+	var err error
+
+	if len(password) != 0 {
+		return nil, 0, fmt.Errorf("%w: password protected links require DropboxV2", ErrNotSupported)
+	}
+	if u, err = url.Parse(link); err != nil {
+		return nil, 0, err
+	}
+	if host := strings.ToLower(u.Host); host == "www.dropbox.com" || host == "dropbox.com" {
+		q := u.Query()
+		q.Del("raw")
+		q.Set("dl", "1")
+		u.RawQuery = q.Encode()
+	}
+	if response, err = db.notifyClient().Get(u.String()); err != nil {
+		return nil, 0, err
+	}
+	if isSuccess(response.StatusCode) {
+		return response.Body, response.ContentLength, nil
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, 0, os.ErrNotExist
+	}
+	return nil, 0, newErrorf(response.StatusCode, "unable to fetch the shared link '%s': HTTP status code %d", link, response.StatusCode)
+}
+
 // Search searches the entries matching all the words contained in query in the given path.
 // The maximum number of entries and whether to consider deleted file may be given.
 func (db *Dropbox) Search(path, query string, fileLimit int, includeDeleted bool) ([]Entry, error) {
+	rv, _, err := db.SearchWithLimit(path, query, fileLimit, includeDeleted)
+	return rv, err
+}
+
+// SearchWithLimit is like Search but also reports whether fileLimit entries were returned,
+// in which case the results may be incomplete.
+// A fileLimit lower or equal to 0 uses SearchLimitDefault, greater values are capped to SearchLimitMax.
+func (db *Dropbox) SearchWithLimit(path, query string, fileLimit int, includeDeleted bool) ([]Entry, bool, error) {
 	var rv []Entry
 	var params *url.Values
 
-	if fileLimit <= 0 || fileLimit > SearchLimitMax {
+	if fileLimit <= 0 {
 		fileLimit = SearchLimitDefault
+	} else if fileLimit > SearchLimitMax {
+		fileLimit = SearchLimitMax
 	}
 	params = &url.Values{
 		"query":           {query},
 		"file_limit":      {strconv.FormatInt(int64(fileLimit), 10)},
 		"include_deleted": {strconv.FormatBool(includeDeleted)},
 	}
-	act := strings.Join([]string{"search", db.RootDirectory, path}, "/")
-	err := db.doRequest("GET", act, params, &rv)
-	return rv, err
+	act := strings.Join([]string{"search", db.RootDirectory, cleanPath(path)}, "/")
+	if err := db.doRequest("GET", act, params, &rv); err != nil {
+		return nil, false, err
+	}
+	return rv, len(rv) >= fileLimit, nil
+}
+
+// HighlightSpan is a part of the name or content of a search match, IsHighlighted if it matches the query.
+type HighlightSpan struct {
+	Text          string `json:"highlight_str"`
+	IsHighlighted bool   `json:"is_highlighted"`
+}
+
+// SearchMatch is an entry found by SearchDetailed.
+// MatchType (filename, file_content...) and Highlights are only sent by the version 2 of the API.
+type SearchMatch struct {
+	Entry      Entry
+	MatchType  string
+	Highlights []HighlightSpan
+}
+
+// SearchResult is the result of SearchDetailed, the matches are sorted by path.
+type SearchResult struct {
+	Matches   []SearchMatch // Live entries matching the query.
+	Deleted   []SearchMatch // Deleted entries matching the query, only if requested.
+	Truncated bool          // The limit was reached, the results may be incomplete.
+}
+
+// newSearchResult splits matches between live and deleted entries and sorts them by path.
+func newSearchResult(matches []SearchMatch, truncated bool) *SearchResult {
+	rv := &SearchResult{Truncated: truncated}
+	for _, m := range matches {
+		if m.Entry.IsDeleted {
+			rv.Deleted = append(rv.Deleted, m)
+		} else {
+			rv.Matches = append(rv.Matches, m)
+		}
+	}
+	for _, list := range [][]SearchMatch{rv.Matches, rv.Deleted} {
+		list := list
+		sort.Slice(list, func(i, j int) bool { return list[i].Entry.Path < list[j].Entry.Path })
+	}
+	return rv
+}
+
+// SearchDetailed is like SearchWithLimit but separates the deleted entries from the live ones.
+func (db *Dropbox) SearchDetailed(path, query string, fileLimit int, includeDeleted bool) (*SearchResult, error) {
+	var matches []SearchMatch
+
+	entries, truncated, err := db.SearchWithLimit(path, query, fileLimit, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		matches = append(matches, SearchMatch{Entry: entries[i]})
+	}
+	return newSearchResult(matches, truncated), nil
+}
+
+// DeltaOptions are the optional parameters of DeltaWithOptions.
+type DeltaOptions struct {
+	PathPrefix       string // Only return the entries under this path.
+	IncludeMediaInfo bool   // Fill PhotoInfo and VideoInfo in the entries.
 }
 
 // Delta gets modifications since the cursor.
 func (db *Dropbox) Delta(cursor, pathPrefix string) (*DeltaPage, error) {
+	return db.DeltaWithOptions(cursor, DeltaOptions{PathPrefix: pathPrefix})
+}
+
+// DeltaWithOptions is like Delta with additional options.
+func (db *Dropbox) DeltaWithOptions(cursor string, o DeltaOptions) (*DeltaPage, error) {
 	var rv DeltaPage
 	var params *url.Values
 	type deltaPageParser struct {
@@ -748,8 +2182,11 @@ func (db *Dropbox) Delta(cursor, pathPrefix string) (*DeltaPage, error) {
 	if len(cursor) != 0 {
 		params.Set("cursor", cursor)
 	}
-	if len(pathPrefix) != 0 {
-		params.Set("path_prefix", pathPrefix)
+	if len(o.PathPrefix) != 0 {
+		params.Set("path_prefix", o.PathPrefix)
+	}
+	if o.IncludeMediaInfo {
+		params.Set("include_media_info", "true")
 	}
 	err := db.doRequest("POST", "delta", params, &dpp)
 	rv = DeltaPage{Reset: dpp.Reset, HasMore: dpp.HasMore, Cursor: dpp.Cursor}
@@ -758,15 +2195,12 @@ func (db *Dropbox) Delta(cursor, pathPrefix string) (*DeltaPage, error) {
 		var path string
 		var entry Entry
 
-		if len(jentry) != 2 {
-			return nil, fmt.Errorf("malformed reply")
-		}
-
-		if err = json.Unmarshal(jentry[0], &path); err != nil {
-			return nil, err
-		}
-		if err = json.Unmarshal(jentry[1], &entry); err != nil {
-			return nil, err
+		if perr := parseDeltaEntry(jentry, &path, &entry); perr != nil {
+			if db.StrictDelta {
+				return nil, perr
+			}
+			db.warnf("skipping delta entry: %v", perr)
+			continue
 		}
 		if entry.Path == "" {
 			rv.Entries = append(rv.Entries, DeltaEntry{Path: path, Entry: nil})
@@ -777,26 +2211,114 @@ func (db *Dropbox) Delta(cursor, pathPrefix string) (*DeltaPage, error) {
 	return &rv, err
 }
 
+// parseDeltaEntry decodes a [path, metadata] pair of a delta reply, a *MalformedDeltaError is returned if invalid.
+func parseDeltaEntry(jentry []json.RawMessage, path *string, entry *Entry) error {
+	var err error
+
+	if len(jentry) != 2 {
+		err = fmt.Errorf("got %d elements expected 2", len(jentry))
+	} else if err = json.Unmarshal(jentry[0], path); err == nil {
+		err = json.Unmarshal(jentry[1], entry)
+	}
+	if err != nil {
+		raw, _ := json.Marshal(jentry)
+		return &MalformedDeltaError{Raw: raw, Err: err}
+	}
+	return nil
+}
+
+// DeltaIterator iterates over the entries returned by successive calls to Delta.
+type DeltaIterator struct {
+	db         *Dropbox
+	cursor     string
+	pathPrefix string
+	page       *DeltaPage
+	index      int
+	reset      bool
+}
+
+// DeltaIterator returns an iterator over the modifications since the cursor.
+// Pages are fetched as needed until one of them is returned with HasMore set to false.
+func (db *Dropbox) DeltaIterator(cursor, pathPrefix string) *DeltaIterator {
+	return &DeltaIterator{db: db, cursor: cursor, pathPrefix: pathPrefix}
+}
+
+// Next returns the next modified entry, io.EOF is returned once all the changes have been read.
+func (it *DeltaIterator) Next() (*DeltaEntry, error) {
+	var page *DeltaPage
+	var err error
+
+	for it.page == nil || it.index >= len(it.page.Entries) {
+		if it.page != nil && !it.page.HasMore {
+			return nil, io.EOF
+		}
+		if page, err = it.db.Delta(it.cursor, it.pathPrefix); err != nil {
+			return nil, err
+		}
+		it.page = page
+		it.index = 0
+		it.cursor = page.Cursor.Cursor
+		if page.Reset {
+			it.reset = true
+		}
+	}
+	it.index++
+	return &it.page.Entries[it.index-1], nil
+}
+
+// ShouldReset returns true if one of the pages read so far asked for the local state to be cleared.
+func (it *DeltaIterator) ShouldReset() bool {
+	return it.reset
+}
+
+// Cursor returns the cursor of the last page read, it should be saved to get the next modifications.
+func (it *DeltaIterator) Cursor() string {
+	return it.cursor
+}
+
 // LongPollDelta waits for a notification to happen.
+// timeout is the maximum time in seconds to wait, it is clamped to [PollMinTimeout; PollMaxTimeout].
+// If timeout is 0, the default timeout of the server is used.
 func (db *Dropbox) LongPollDelta(cursor string, timeout int) (*DeltaPoll, error) {
+	return db.LongPollDeltaContext(db.ctx, cursor, timeout)
+}
+
+// LongPollDeltaContext is like LongPollDelta but returns as soon as ctx is done.
+// The request is sent without the OAuth token with HTTPClient (or http.DefaultClient), its time limit is
+// replaced by the poll timeout plus PollTimeoutMargin seconds.
+func (db *Dropbox) LongPollDeltaContext(ctx context.Context, cursor string, timeout int) (*DeltaPoll, error) {
 	var rv DeltaPoll
 	var params *url.Values
 	var body []byte
 	var rawurl string
+	var request *http.Request
 	var response *http.Response
 	var err error
-	var client http.Client
 
 	params = &url.Values{}
-	if timeout != 0 {
-		if timeout < PollMinTimeout || timeout > PollMaxTimeout {
-			return nil, fmt.Errorf("timeout out of range [%d; %d]", PollMinTimeout, PollMaxTimeout)
+	if timeout < 0 {
+		return nil, fmt.Errorf("invalid negative timeout %d", timeout)
+	} else if timeout != 0 {
+		if timeout < PollMinTimeout {
+			timeout = PollMinTimeout
+		} else if timeout > PollMaxTimeout {
+			timeout = PollMaxTimeout
 		}
 		params.Set("timeout", strconv.FormatInt(int64(timeout), 10))
 	}
 	params.Set("cursor", cursor)
 	rawurl = fmt.Sprintf("%s/longpoll_delta?%s", db.APINotifyURL, params.Encode())
-	if response, err = client.Get(rawurl); err != nil {
+	if request, err = http.NewRequest("GET", rawurl, nil); err != nil {
+		return nil, err
+	}
+	// The server replies after timeout seconds (30 by default) with some jitter.
+	wait := timeout
+	if wait == 0 {
+		wait = PollMinTimeout
+	}
+	client := *db.notifyClient()
+	client.Timeout = time.Duration(wait+PollTimeoutMargin) * time.Second
+	if response, err = client.Do(request.WithContext(ctx)); err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
@@ -807,44 +2329,124 @@ func (db *Dropbox) LongPollDelta(cursor string, timeout int) (*DeltaPoll, error)
 	return &rv, err
 }
 
+// MetadataOptions are the optional parameters of MetadataOpts.
+type MetadataOptions struct {
+	List             bool   // Send the immediate children of a directory in the Contents field.
+	IncludeDeleted   bool   // Also send the deleted entries.
+	Hash             string // Hash of the contents of a directory, ErrNotModified is returned if it did not change.
+	Rev              string // Specific revision to get the metadata from.
+	Limit            int    // Maximum number of entries, MetadataLimitDefault if lower or equal to 0.
+	IncludeMediaInfo bool   // Fill PhotoInfo and VideoInfo in the entries.
+}
+
 // Metadata gets the metadata for a file or a directory.
 // If list is true and src is a directory, immediate child will be sent in the Contents field.
 // If include_deleted is true, entries deleted will be sent.
-// hash is the hash of the contents of a directory, it is used to avoid sending data when directory did not change.
+// hash is the hash of the contents of a directory, it is used to avoid sending data when directory did not change,
+// ErrNotModified is then returned.
 // rev is the specific revision to get the metadata from.
 // limit is the maximum number of entries requested.
 func (db *Dropbox) Metadata(src string, list bool, includeDeleted bool, hash, rev string, limit int) (*Entry, error) {
+	return db.MetadataOpts(src, MetadataOptions{List: list, IncludeDeleted: includeDeleted, Hash: hash, Rev: rev, Limit: limit})
+}
+
+// MetadataOpts gets the metadata for a file or a directory, see MetadataOptions.
+func (db *Dropbox) MetadataOpts(src string, o MetadataOptions) (*Entry, error) {
 	var rv Entry
 	var params *url.Values
 
+	limit := o.Limit
 	if limit <= 0 {
 		limit = MetadataLimitDefault
 	} else if limit > MetadataLimitMax {
 		limit = MetadataLimitMax
 	}
 	params = &url.Values{
-		"list":            {strconv.FormatBool(list)},
-		"include_deleted": {strconv.FormatBool(includeDeleted)},
+		"list":            {strconv.FormatBool(o.List)},
+		"include_deleted": {strconv.FormatBool(o.IncludeDeleted)},
 		"file_limit":      {strconv.FormatInt(int64(limit), 10)},
 	}
-	if len(rev) != 0 {
-		params.Set("rev", rev)
+	if len(o.Rev) != 0 {
+		params.Set("rev", o.Rev)
+	}
+	if len(o.Hash) != 0 {
+		params.Set("hash", o.Hash)
 	}
-	if len(hash) != 0 {
-		params.Set("hash", hash)
+	if o.IncludeMediaInfo {
+		params.Set("include_media_info", "true")
 	}
 
-	src = strings.Trim(src, "/")
-	act := strings.Join([]string{"metadata", db.RootDirectory, src}, "/")
+	act := strings.Join([]string{"metadata", db.RootDirectory, cleanPath(src)}, "/")
 	err := db.doRequest("GET", act, params, &rv)
 	return &rv, err
 }
 
+// ListFolder returns the immediate children of the directory located at path and the hash of its contents.
+// The hash can be given to Metadata to avoid receiving the list again when the directory did not change.
+func (db *Dropbox) ListFolder(path string, includeDeleted bool) ([]Entry, string, error) {
+	var entry *Entry
+	var err error
+
+	if entry, err = db.base().Metadata(path, true, includeDeleted, "", "", 0); err != nil {
+		return nil, "", err
+	}
+	if !entry.IsDir {
+		return nil, "", fmt.Errorf("%s is not a directory", path)
+	}
+	return entry.Contents, entry.Hash, nil
+}
+
+// SaveURLJob represents the state of a job started by SaveURL.
+type SaveURLJob struct {
+	Status   string `json:"status"`             // PENDING, DOWNLOADING, COMPLETE or FAILED.
+	Job      string `json:"job,omitempty"`      // ID of the job.
+	Error    string `json:"error,omitempty"`    // Reason of the failure.
+	Metadata *Entry `json:"metadata,omitempty"` // Metadata of the file when the job is complete and it is sent by the server.
+}
+
+// Status of a SaveURL job.
+const (
+	SaveURLPending     = "PENDING"
+	SaveURLDownloading = "DOWNLOADING"
+	SaveURLComplete    = "COMPLETE"
+	SaveURLFailed      = "FAILED"
+)
+
+// SaveURL asks the server to download the content of rawurl to the dst path on Dropbox.
+// The download is asynchronous, the ID of the job is returned to poll its state with SaveURLJobStatus.
+func (db *Dropbox) SaveURL(dst, rawurl string) (string, error) {
+	var rv SaveURLJob
+
+	if err := ValidatePath(dst); err != nil {
+		return "", err
+	}
+	act := strings.Join([]string{"save_url", db.RootDirectory, cleanPath(dst)}, "/")
+	if err := db.doRequest("POST", act, &url.Values{"url": {rawurl}}, &rv); err != nil {
+		return "", err
+	}
+	return rv.Job, nil
+}
+
+// SaveURLJobStatus gets the status of a job started by SaveURL.
+// The metadata of the file is only returned when the job is complete and the server sent it.
+// An error is returned with the reason sent by the server if the job failed.
+func (db *Dropbox) SaveURLJobStatus(jobID string) (string, *Entry, error) {
+	var rv SaveURLJob
+
+	if err := db.doRequest("GET", "save_url_job/"+jobID, nil, &rv); err != nil {
+		return "", nil, err
+	}
+	if rv.Status == SaveURLFailed {
+		return rv.Status, nil, fmt.Errorf("save_url job %s failed: %s", jobID, rv.Error)
+	}
+	return rv.Status, rv.Metadata, nil
+}
+
 // CopyRef gets a reference to a file.
 // This reference can be used to copy this file to another user's Dropbox by passing it to the Copy method.
 func (db *Dropbox) CopyRef(src string) (*CopyRef, error) {
 	var rv CopyRef
-	act := strings.Join([]string{"copy_ref", db.RootDirectory, src}, "/")
+	act := strings.Join([]string{"copy_ref", db.RootDirectory, cleanPath(src)}, "/")
 	err := db.doRequest("GET", act, nil, &rv)
 	return &rv, err
 }
@@ -857,61 +2459,286 @@ func (db *Dropbox) Revisions(src string, revLimit int) ([]Entry, error) {
 	} else if revLimit > RevisionsLimitMax {
 		revLimit = RevisionsLimitMax
 	}
-	act := strings.Join([]string{"revisions", db.RootDirectory, src}, "/")
+	act := strings.Join([]string{"revisions", db.RootDirectory, cleanPath(src)}, "/")
 	err := db.doRequest("GET", act,
 		&url.Values{"rev_limit": {strconv.FormatInt(int64(revLimit), 10)}}, &rv)
 	return rv, err
 }
 
+// RevisionsSorted is like Revisions but the entries are sorted by modification date, the newest first.
+// The entries without a modification date are put last and reported to Warnf.
+func (db *Dropbox) RevisionsSorted(src string, revLimit int) ([]Entry, error) {
+	var rv []Entry
+	var err error
+
+	if rv, err = db.Revisions(src, revLimit); err != nil {
+		return nil, err
+	}
+	times := make(map[string]time.Time, len(rv))
+	for i := range rv {
+		if t, terr := rv[i].ModifiedTime(); terr == nil {
+			times[rv[i].Revision] = t
+		} else {
+			db.warnf("revision %s of '%s': %v", rv[i].Revision, src, terr)
+		}
+	}
+	sort.SliceStable(rv, func(i, j int) bool {
+		ti, iok := times[rv[i].Revision]
+		tj, jok := times[rv[j].Revision]
+		if iok != jok {
+			return iok
+		}
+		return ti.After(tj)
+	})
+	return rv, nil
+}
+
 // Restore restores a deleted file at the corresponding revision.
 func (db *Dropbox) Restore(src string, rev string) (*Entry, error) {
 	var rv Entry
-	act := strings.Join([]string{"restore", db.RootDirectory, src}, "/")
+	act := strings.Join([]string{"restore", db.RootDirectory, cleanPath(src)}, "/")
 	err := db.doRequest("POST", act, &url.Values{"rev": {rev}}, &rv)
 	return &rv, err
 }
 
+// RestoreToPath restores the revision rev of src at dst, src is left at its current revision.
+// The API only restores in place so src is restored, copied to dst and then put back at its current revision
+// (or deleted again if it was deleted).
+// If the copy fails or src cannot be put back, the error returned reports both failures.
+func (db *Dropbox) RestoreToPath(src, rev, dst string) (*Entry, error) {
+	var current, rv *Entry
+	var err error
+
+	if current, err = db.base().Metadata(src, false, true, "", "", 0); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		current = nil
+	}
+	if current != nil && !current.IsDeleted && current.Revision == rev {
+		return db.Copy(src, dst, false)
+	}
+	if _, err = db.Restore(src, rev); err != nil {
+		return nil, err
+	}
+	rv, err = db.Copy(src, dst, false)
+	if current != nil && !current.IsDeleted {
+		_, perr := db.Restore(src, current.Revision)
+		return rv, joinRestoreErrors(src, err, perr)
+	}
+	_, perr := db.Delete(src)
+	return rv, joinRestoreErrors(src, err, perr)
+}
+
+// joinRestoreErrors combines the error of the copy and of putting src back done by RestoreToPath.
+func joinRestoreErrors(src string, cerr, perr error) error {
+	switch {
+	case perr == nil:
+		return cerr
+	case cerr == nil:
+		return fmt.Errorf("restored but could not put '%s' back: %w", src, perr)
+	}
+	return fmt.Errorf("copy failed: %v; could not put '%s' back: %w", cerr, src, perr)
+}
+
 // Copy copies a file.
 // If isRef is true src must be a reference from CopyRef instead of a path.
+// If dst already exists, the error returned matches ErrTargetExists with errors.Is.
 func (db *Dropbox) Copy(src, dst string, isRef bool) (*Entry, error) {
 	var rv Entry
-	params := &url.Values{"root": {db.RootDirectory}, "to_path": {dst}}
+	if err := ValidatePath(dst); err != nil {
+		return nil, err
+	}
+	params := &url.Values{"root": {db.RootDirectory}, "to_path": {cleanPath(dst)}}
 	if isRef {
 		params.Set("from_copy_ref", src)
 	} else {
-		params.Set("from_path", src)
+		params.Set("from_path", cleanPath(src))
 	}
 	err := db.doRequest("POST", "fileops/copy", params, &rv)
 	return &rv, err
 }
 
 // CreateFolder creates a new directory.
+// If path already exists, the error returned matches ErrTargetExists with errors.Is.
 func (db *Dropbox) CreateFolder(path string) (*Entry, error) {
 	var rv Entry
 	err := db.doRequest("POST", "fileops/create_folder",
-		&url.Values{"root": {db.RootDirectory}, "path": {path}}, &rv)
+		&url.Values{"root": {db.RootDirectory}, "path": {cleanPath(path)}}, &rv)
 	return &rv, err
 }
 
+// EnsureFolder creates the directory path and its missing parents like mkdir -p.
+// If path already exists and is a directory, its metadata is returned instead of an error.
+func (db *Dropbox) EnsureFolder(path string) (*Entry, error) {
+	var entry *Entry
+	var err error
+
+	path = cleanPath(path)
+	if entry, err = db.CreateFolder(path); err == nil {
+		return entry, nil
+	}
+	if errors.Is(err, ErrTargetExists) {
+		return db.existingFolder(path, err)
+	}
+	// The parent is strictly shorter than path so the recursion ends at the root.
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || !(isParentNotFound(err) || errors.Is(err, os.ErrNotExist)) {
+		return nil, err
+	}
+	if _, err = db.EnsureFolder(path[:i]); err != nil {
+		return nil, err
+	}
+	if entry, err = db.CreateFolder(path); err != nil && errors.Is(err, ErrTargetExists) {
+		return db.existingFolder(path, err)
+	}
+	return entry, err
+}
+
+// existingFolder returns the metadata of the directory path which CreateFolder reported as existing with err.
+// err is returned if path is not a directory.
+func (db *Dropbox) existingFolder(path string, err error) (*Entry, error) {
+	entry, merr := db.base().Metadata(path, false, false, "", "", 0)
+	if merr != nil {
+		return nil, merr
+	}
+	if !entry.IsDir || entry.IsDeleted {
+		return nil, err
+	}
+	return entry, nil
+}
+
 // Delete removes a file or directory (it is a recursive delete).
+// If path does not exist, the *APIError returned matches os.ErrNotExist with errors.Is and keeps the reason sent by the server.
 func (db *Dropbox) Delete(path string) (*Entry, error) {
 	var rv Entry
 	err := db.doRequest("POST", "fileops/delete",
-		&url.Values{"root": {db.RootDirectory}, "path": {path}}, &rv)
+		&url.Values{"root": {db.RootDirectory}, "path": {cleanPath(path)}}, &rv)
 	return &rv, err
 }
 
+// PermanentlyDelete removes a file or directory and all its revisions, it cannot be restored afterwards.
+// It is only available for Dropbox Business accounts whose team allows permanent deletion,
+// the error returned otherwise matches ErrNotSupported with errors.Is.
+func (db *Dropbox) PermanentlyDelete(path string) error {
+	var rv Entry
+	var ae *APIError
+
+	err := db.doRequest("POST", "fileops/permanently_delete",
+		&url.Values{"root": {db.RootDirectory}, "path": {cleanPath(path)}}, &rv)
+	if errors.As(err, &ae) && ae.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: %v", ErrNotSupported, err)
+	}
+	return err
+}
+
 // Move moves a file or directory.
+// If dst already exists, the error returned matches ErrTargetExists with errors.Is.
 func (db *Dropbox) Move(src, dst string) (*Entry, error) {
 	var rv Entry
+	if err := ValidatePath(dst); err != nil {
+		return nil, err
+	}
 	err := db.doRequest("POST", "fileops/move",
 		&url.Values{"root": {db.RootDirectory},
-			"from_path": {src},
-			"to_path":   {dst}}, &rv)
+			"from_path": {cleanPath(src)},
+			"to_path":   {cleanPath(dst)}}, &rv)
 	return &rv, err
 }
 
+// Rename renames the file or directory located at src to newName in the same folder.
+// newName is a single path component, an error is returned if it is empty or contains a slash.
+func (db *Dropbox) Rename(src, newName string) (*Entry, error) {
+	src = cleanPath(src)
+	if len(src) == 0 {
+		return nil, fmt.Errorf("the root folder cannot be renamed")
+	}
+	if len(newName) == 0 || newName == "." || newName == ".." || strings.Contains(newName, "/") {
+		return nil, fmt.Errorf("invalid name %q: must be a single path component", newName)
+	}
+	return db.Move(src, path.Join(path.Dir("/"+src), newName))
+}
+
+// MoveMkdir is like Move but creates the missing parent folders of dst and retries once
+// if the move failed because the destination folder does not exist.
+// The error of the move is returned if a parent folder cannot be created.
+func (db *Dropbox) MoveMkdir(src, dst string) (*Entry, error) {
+	var rv *Entry
+	var err error
+
+	if rv, err = db.Move(src, dst); err == nil || !isParentNotFound(err) {
+		return rv, err
+	}
+	if merr := db.mkdirParents(dst); merr != nil {
+		return rv, err
+	}
+	return db.Move(src, dst)
+}
+
+// SafeMove is like Move but falls back to a Copy followed by a Delete of src when the server refuses
+// to move across namespaces (like into or out of a shared folder), the fallback is reported to Warnf.
+// src is only deleted once the copy succeeded, if the deletion fails the copy is returned with the error.
+func (db *Dropbox) SafeMove(src, dst string) (*Entry, error) {
+	var rv *Entry
+	var err error
+
+	if rv, err = db.Move(src, dst); err == nil || !isCrossNamespace(err) {
+		return rv, err
+	}
+	db.warnf("moving '%s' to '%s' by copy and delete: %v", cleanPath(src), cleanPath(dst), err)
+	if rv, err = db.Copy(src, dst, false); err != nil {
+		return nil, err
+	}
+	if _, err = db.Delete(src); err != nil {
+		return rv, fmt.Errorf("copied to '%s' but could not delete '%s': %w", cleanPath(dst), cleanPath(src), err)
+	}
+	return rv, nil
+}
+
+// isCrossNamespace returns true if err reports that a move across namespaces is not allowed.
+func isCrossNamespace(err error) bool {
+	var ae *APIError
+
+	if !errors.As(err, &ae) {
+		return false
+	}
+	reason := strings.ToLower(ae.Reason)
+	return strings.Contains(reason, "namespace") || strings.Contains(reason, "cant_move_shared_folder") ||
+		strings.Contains(reason, "cant_nest_shared_folder")
+}
+
+// isParentNotFound returns true if err reports that the parent folder of the destination does not exist.
+func isParentNotFound(err error) bool {
+	var ae *APIError
+
+	if !errors.As(err, &ae) {
+		return false
+	}
+	reason := strings.ToLower(ae.Reason)
+	return strings.Contains(reason, "parent") || strings.HasPrefix(reason, "to/not_found")
+}
+
+// mkdirParents creates all the ancestors of path, from the root to its direct parent.
+func (db *Dropbox) mkdirParents(path string) error {
+	var entry *Entry
+	var err error
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		if _, err = db.CreateFolder(dir); err == nil {
+			continue
+		}
+		if entry, _ = db.base().Metadata(dir, false, false, "", "", 0); entry == nil || !entry.IsDir || entry.IsDeleted {
+			return err
+		}
+	}
+	return nil
+}
+
 // LatestCursor returns the latest cursor without fetching any data.
+// Unlike calling Delta with an empty cursor, the history is not replayed so it is the cheap way
+// to get a cursor to give to Watch or LongPollDelta when only the future changes matter.
 func (db *Dropbox) LatestCursor(prefix string, mediaInfo bool) (*Cursor, error) {
 	var (
 		params = &url.Values{}
@@ -938,36 +2765,14 @@ type LinkParams struct {
 	Path string `json:"path"`
 }
 
+// GetTemporaryLink returns a direct link to the content of the file located at path.
+// Deprecated: use TemporaryLink which also returns the metadata.
 func (db *Dropbox) GetTemporaryLink(path string) (string, error) {
-	var rv TemporaryLinkResponse
-	var lp LinkParams
-	lp.Path = path
-	
-	body, err := json.Marshal(lp)
-	if err != nil {
-		panic(err)
-	}
-	
-	req, err := http.NewRequest("POST", "https://api.dropboxapi.com/2/files/get_temporary_link", bytes.NewReader(body))
-	if err != nil { panic(err) }
-	
-	req.Header["Content-Type"] = []string{"application/json"}
-	
-	response, err := db.client().Do(req)
-	
-	data, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		panic(err)
-	}
-	
-	fmt.Println( bytes.NewBuffer(data).String() )
-	
-	err = json.Unmarshal( data, &rv)
+	link, _, err := db.TemporaryLink(path)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	
-	return rv.Link, err
+	return link.URL, nil
 }
 
 // SharedFolders returns the list of allowed shared folders.
@@ -977,9 +2782,9 @@ func (db *Dropbox) SharedFolders(sharedFolderID string) ([]SharedFolder, error)
 
 	if sharedFolderID != "" {
 		sharedFolders = make([]SharedFolder, 1)
-		err = db.doRequest("GET", "/shared_folders/"+sharedFolderID, nil, &sharedFolders[0])
+		err = db.doRequest("GET", "shared_folders/"+sharedFolderID, nil, &sharedFolders[0])
 	} else {
-		err = db.doRequest("GET", "/shared_folders/", nil, &sharedFolders)
+		err = db.doRequest("GET", "shared_folders", nil, &sharedFolders)
 	}
 	return sharedFolders, err
 }
@@ -27,14 +27,26 @@ package dropbox
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
 )
 
 var dirEntry = Entry{Size: "0 bytes", Revision: "1f477dd351f", ThumbExists: false, Bytes: 0,
@@ -47,13 +59,16 @@ var fileEntry = Entry{Size: "0 bytes", Revision: "1f33043551f", ThumbExists: fal
 	Root: "auto", MimeType: "text/plain"}
 
 type FakeHTTP struct {
-	t            *testing.T
-	Method       string
-	Host         string
-	Path         string
-	Params       map[string]string
-	RequestData  []byte
-	ResponseData []byte
+	t              *testing.T
+	Method         string
+	Host           string
+	Path           string
+	Params         map[string]string
+	Headers        map[string]string
+	RequestData    []byte
+	ResponseData   []byte
+	ResponseHeader http.Header
+	StatusCode     int // 200 when not set.
 }
 
 func (f FakeHTTP) RoundTrip(req *http.Request) (resp *http.Response, err error) {
@@ -84,6 +99,11 @@ func (f FakeHTTP) checkRequest(r *http.Request) (*http.Response, error) {
 			return nil, fmt.Errorf("wrong parameters %s expected %s received %s", k, v, va[0])
 		}
 	}
+	for k, v := range f.Headers {
+		if r.Header.Get(k) != v {
+			return nil, fmt.Errorf("wrong header %s expected %s received %s", k, v, r.Header.Get(k))
+		}
+	}
 	if len(f.RequestData) != 0 {
 		var buf []byte
 		var err error
@@ -96,8 +116,11 @@ func (f FakeHTTP) checkRequest(r *http.Request) (*http.Response, error) {
 		}
 	}
 
-	return &http.Response{Status: "200 OK", StatusCode: 200,
-		Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+	if f.StatusCode == 0 {
+		f.StatusCode = http.StatusOK
+	}
+	return &http.Response{Status: http.StatusText(f.StatusCode), StatusCode: f.StatusCode,
+		Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1, Header: f.ResponseHeader,
 		ContentLength: int64(len(f.ResponseData)), Body: ioutil.NopCloser(bytes.NewReader(f.ResponseData))}, nil
 }
 
@@ -150,6 +173,60 @@ func TestAccountInfo(t *testing.T) {
 	}
 }
 
+func TestSpaceUsage(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *SpaceUsage
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/account/info",
+			Params:       map[string]string{"locale": "en"},
+			ResponseData: []byte(`{"uid": 12345678, "quota_info": {"shared": 253738410565, "quota": 107374182400000, "normal": 680031877871}}`),
+		},
+	}
+
+	expected := SpaceUsage{Used: 253738410565 + 680031877871, Allocation: SpaceAllocation{Type: "individual", Allocated: 107374182400000}}
+	if received, err = db.SpaceUsage(); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+}
+
+func TestStrictJSON(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Account
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/account/info",
+			Params:       map[string]string{"locale": "en"},
+			ResponseData: []byte(`{"display_name": "John P. User", "uid": 12345678, "new_field": true}`),
+		},
+	}
+
+	if received, err = db.GetAccountInfo(); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if received.DisplayName != "John P. User" || received.UID != 12345678 {
+		t.Errorf("wrong account %#v", *received)
+	}
+
+	db.StrictJSON = true
+	if _, err = db.GetAccountInfo(); err == nil || !strings.Contains(err.Error(), "new_field") {
+		t.Errorf("unknown field should be rejected, got %v", err)
+	}
+}
+
 func TestCopy(t *testing.T) {
 	var err error
 	var db *Dropbox
@@ -200,6 +277,197 @@ func TestCopy(t *testing.T) {
 	}
 }
 
+func TestUnexpectedStatus(t *testing.T) {
+	var err error
+	var ae *APIError
+	var e *Error
+
+	fake := FakeHTTP{
+		t:      t,
+		Method: "POST",
+		Host:   "api.dropbox.com",
+		Path:   "/1/fileops/create_folder",
+		Params: map[string]string{"root": "auto", "path": "b", "locale": "en"},
+	}
+	db := newDropbox(t)
+
+	fake.StatusCode = http.StatusForbidden
+	fake.ResponseData = []byte(`{"error": "Access denied"}`)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if _, err = db.CreateFolder("b"); !errors.As(err, &ae) || ae.StatusCode != http.StatusForbidden || ae.Reason != "Access denied" {
+		t.Errorf("got %#v expected an APIError with the reason of the server", err)
+	}
+
+	fake.ResponseData = []byte(`<html>Forbidden</html>`)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if _, err = db.CreateFolder("b"); !errors.As(err, &e) || e.StatusCode != http.StatusForbidden {
+		t.Errorf("got %#v expected an Error with status 403", err)
+	}
+
+	fake.StatusCode = http.StatusInsufficientStorage
+	fake.ResponseData = []byte(`{"error": "This operation would exceed your quota"}`)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if _, err = db.CreateFolder("b"); !errors.Is(err, ErrInsufficientStorage) || !errors.As(err, &ae) {
+		t.Errorf("got %v expected ErrInsufficientStorage", err)
+	}
+
+	fake.ResponseData = nil
+	http.DefaultClient = &http.Client{Transport: fake}
+	if _, err = db.CreateFolder("b"); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("got %v expected ErrInsufficientStorage", err)
+	}
+}
+
+func TestTargetExists(t *testing.T) {
+	var err error
+	var db *Dropbox
+
+	tab := []struct {
+		path   string
+		params map[string]string
+		status int
+		reason string
+		exists bool
+		call   func() error
+	}{
+		{
+			path:   "/1/fileops/copy",
+			params: map[string]string{"root": "auto", "from_path": "a", "to_path": "b", "locale": "en"},
+			status: http.StatusForbidden,
+			reason: "A file with that name already exists at path '/b'.",
+			exists: true,
+			call:   func() error { _, err := db.Copy("a", "b", false); return err },
+		},
+		{
+			path:   "/1/fileops/move",
+			params: map[string]string{"root": "auto", "from_path": "a", "to_path": "b", "locale": "en"},
+			status: http.StatusBadRequest,
+			reason: "A file with that name already exists at path '/b'.",
+			exists: true,
+			call:   func() error { _, err := db.Move("a", "b"); return err },
+		},
+		{
+			path:   "/1/fileops/create_folder",
+			params: map[string]string{"root": "auto", "path": "b", "locale": "en"},
+			status: http.StatusForbidden,
+			reason: "A file or folder already exists at path '/b'.",
+			exists: true,
+			call:   func() error { _, err := db.CreateFolder("b"); return err },
+		},
+		{
+			path:   "/1/fileops/move",
+			params: map[string]string{"root": "auto", "from_path": "a", "to_path": "b", "locale": "en"},
+			status: http.StatusConflict,
+			reason: "to/conflict/file/",
+			exists: true,
+			call:   func() error { _, err := db.Move("a", "b"); return err },
+		},
+		{
+			path:   "/1/fileops/move",
+			params: map[string]string{"root": "auto", "from_path": "a", "to_path": "b", "locale": "en"},
+			status: http.StatusBadRequest,
+			reason: "Invalid path",
+			exists: false,
+			call:   func() error { _, err := db.Move("a", "b"); return err },
+		},
+	}
+
+	db = newDropbox(t)
+	for _, tc := range tab {
+		js, _ := json.Marshal(map[string]string{"error": tc.reason})
+		http.DefaultClient = &http.Client{
+			Transport: FakeHTTP{
+				t:            t,
+				Method:       "POST",
+				Host:         "api.dropbox.com",
+				Path:         tc.path,
+				Params:       tc.params,
+				StatusCode:   tc.status,
+				ResponseData: js,
+			},
+		}
+		if err = tc.call(); err == nil {
+			t.Errorf("%s: an error was expected", tc.path)
+		} else if errors.Is(err, ErrTargetExists) != tc.exists {
+			t.Errorf("%s: %q matches ErrTargetExists: %v expected %v", tc.path, tc.reason, !tc.exists, tc.exists)
+		}
+	}
+}
+
+func TestSaveURL(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var index int
+	var job, status string
+	var entry *Entry
+
+	expected := fileEntry
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			{
+				t:            t,
+				Method:       "POST",
+				Host:         "api.dropbox.com",
+				Path:         "/1/save_url/auto/testfile",
+				Params:       map[string]string{"locale": "en", "url": "https://example.com/file?id=1"},
+				ResponseData: []byte(`{"status": "PENDING", "job": "PEiuxsfaISEAAAAAAADw7g"}`),
+			},
+			{
+				t:            t,
+				Method:       "GET",
+				Host:         "api.dropbox.com",
+				Path:         "/1/save_url_job/PEiuxsfaISEAAAAAAADw7g",
+				Params:       map[string]string{"locale": "en"},
+				ResponseData: []byte(`{"status": "DOWNLOADING"}`),
+			},
+			{
+				t:            t,
+				Method:       "GET",
+				Host:         "api.dropbox.com",
+				Path:         "/1/save_url_job/PEiuxsfaISEAAAAAAADw7g",
+				Params:       map[string]string{"locale": "en"},
+				ResponseData: []byte(`{"status": "COMPLETE", "metadata": ` + string(js) + `}`),
+			},
+			{
+				t:            t,
+				Method:       "GET",
+				Host:         "api.dropbox.com",
+				Path:         "/1/save_url_job/PEiuxsfaISEAAAAAAADw7g",
+				Params:       map[string]string{"locale": "en"},
+				ResponseData: []byte(`{"status": "FAILED", "error": "Not found"}`),
+			},
+		}},
+	}
+
+	if job, err = db.SaveURL("testfile", "https://example.com/file?id=1"); err != nil {
+		t.Fatalf("API error: %s", err)
+	} else if job != "PEiuxsfaISEAAAAAAADw7g" {
+		t.Errorf("got job %s expected PEiuxsfaISEAAAAAAADw7g", job)
+	}
+
+	if status, entry, err = db.SaveURLJobStatus(job); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if status != SaveURLDownloading || entry != nil {
+		t.Errorf("got %s %#v expected %s without metadata", status, entry, SaveURLDownloading)
+	}
+
+	if status, entry, err = db.SaveURLJobStatus(job); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if status != SaveURLComplete || entry == nil || !reflect.DeepEqual(expected, *entry) {
+		t.Errorf("got %s %#v expected %s %#v", status, entry, SaveURLComplete, expected)
+	}
+
+	if status, _, err = db.SaveURLJobStatus(job); err == nil || status != SaveURLFailed {
+		t.Errorf("got %s, %v expected a failure", status, err)
+	}
+}
+
 func TestCopyRef(t *testing.T) {
 	var err error
 	var db *Dropbox
@@ -303,6 +571,184 @@ func TestDelete(t *testing.T) {
 	} else if !reflect.DeepEqual(expected, *received) {
 		t.Errorf("got %#v expected %#v", *received, expected)
 	}
+
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "POST",
+			Host:   "api.dropbox.com",
+			Path:   "/1/fileops/delete",
+			Params: map[string]string{
+				"root":   "auto",
+				"path":   path,
+				"locale": "en",
+			},
+			StatusCode:   http.StatusNotFound,
+			ResponseData: []byte(`{"error": "Path '/testdir' not found"}`),
+		},
+	}
+	_, err = db.Delete(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v expected os.ErrNotExist", err)
+	}
+	if err == nil || err.Error() != "Path '/testdir' not found" {
+		t.Errorf("reason was not preserved: %v", err)
+	}
+
+	for _, body := range []string{"", " \n"} {
+		http.DefaultClient = &http.Client{
+			Transport: FakeHTTP{
+				t:      t,
+				Method: "POST",
+				Host:   "api.dropbox.com",
+				Path:   "/1/fileops/delete",
+				Params: map[string]string{
+					"root":   "auto",
+					"path":   path,
+					"locale": "en",
+				},
+				ResponseData: []byte(body),
+			},
+		}
+		if received, err = db.Delete(path); err != nil {
+			t.Errorf("API error: %s", err)
+		} else if !reflect.DeepEqual(Entry{}, *received) {
+			t.Errorf("got %#v expected a zero Entry", *received)
+		}
+	}
+
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "POST",
+			Host:   "api.dropbox.com",
+			Path:   "/1/fileops/delete",
+			Params: map[string]string{
+				"root":   "auto",
+				"path":   path,
+				"locale": "en",
+			},
+			StatusCode: http.StatusNoContent,
+		},
+	}
+	if received, err = db.Delete(path); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(Entry{}, *received) {
+		t.Errorf("got %#v expected a zero Entry", *received)
+	}
+}
+
+func TestPermanentlyDelete(t *testing.T) {
+	var err error
+
+	path := "testfile"
+	fake := FakeHTTP{
+		t:      t,
+		Method: "POST",
+		Host:   "api.dropbox.com",
+		Path:   "/1/fileops/permanently_delete",
+		Params: map[string]string{
+			"root":   "auto",
+			"path":   path,
+			"locale": "en",
+		},
+	}
+	db := newDropbox(t)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if err = db.PermanentlyDelete(path); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+
+	fake.StatusCode = http.StatusForbidden
+	fake.ResponseData = []byte(`{"error": "Permanent deletion is only available for Dropbox Business accounts"}`)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if err = db.PermanentlyDelete(path); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("got %v expected ErrNotSupported", err)
+	}
+
+	fake.StatusCode = http.StatusNotFound
+	fake.ResponseData = []byte(`{"error": "Path '/testfile' not found"}`)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if err = db.PermanentlyDelete(path); !errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrNotSupported) {
+		t.Errorf("got %v expected os.ErrNotExist", err)
+	}
+}
+
+func TestUploadFileIfChanged(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var index int
+	var tmpdir string
+	var received *Entry
+	var uploaded bool
+
+	content := []byte("file content")
+	sum, _ := ContentHash(bytes.NewReader(content))
+	if tmpdir, err = ioutil.TempDir("", "dropbox"); err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	src := filepath.Join(tmpdir, "test.txt")
+	if err = ioutil.WriteFile(src, content, 0644); err != nil {
+		t.Fatalf("could not create file: %s", err)
+	}
+
+	remote := Entry{Path: "/test.txt", Bytes: int64(len(content)), ContentHash: sum, Revision: "1"}
+	metadata := func(entry Entry, status int) FakeHTTP {
+		js, _ := json.Marshal(entry)
+		if status == http.StatusNotFound {
+			js = []byte(`{"error": "Path '/test.txt' not found"}`)
+		}
+		return FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/metadata/auto/test.txt",
+			Params:       map[string]string{"list": "false", "include_deleted": "false", "file_limit": "10000", "locale": "en"},
+			StatusCode:   status,
+			ResponseData: js,
+		}
+	}
+	uploadedEntry := remote
+	uploadedEntry.Revision = "2"
+	js, _ := json.Marshal(uploadedEntry)
+	put := FakeHTTP{
+		t:            t,
+		Method:       "PUT",
+		Host:         "api-content.dropbox.com",
+		Path:         "/1/files_put/auto/test.txt",
+		Params:       map[string]string{"locale": "en", "overwrite": "true"},
+		RequestData:  content,
+		ResponseData: js,
+	}
+	changed := remote
+	changed.ContentHash = strings.Repeat("0", 64)
+
+	tab := []struct {
+		pages    []FakeHTTP
+		expected Entry
+		uploaded bool
+	}{
+		{[]FakeHTTP{metadata(remote, http.StatusOK)}, remote, false},
+		{[]FakeHTTP{metadata(changed, http.StatusOK), put}, uploadedEntry, true},
+		{[]FakeHTTP{metadata(Entry{}, http.StatusNotFound), put}, uploadedEntry, true},
+	}
+
+	db = newDropbox(t)
+	for _, tc := range tab {
+		index = 0
+		http.DefaultClient = &http.Client{
+			Transport: pagesHTTP{index: &index, pages: tc.pages},
+		}
+		if received, uploaded, err = db.UploadFileIfChanged(src, "test.txt", true, ""); err != nil {
+			t.Errorf("API error: %s", err)
+		} else if uploaded != tc.uploaded || !reflect.DeepEqual(tc.expected, *received) {
+			t.Errorf("got %#v uploaded %v expected %#v uploaded %v", *received, uploaded, tc.expected, tc.uploaded)
+		}
+		if index != len(tc.pages) {
+			t.Errorf("got %d requests expected %d", index, len(tc.pages))
+		}
+	}
 }
 
 func TestFilesPut(t *testing.T) {
@@ -404,6 +850,105 @@ func TestMedia(t *testing.T) {
 	}
 }
 
+func TestDownloadSharedLink(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var index int
+	var body io.ReadCloser
+	var size int64
+
+	content := []byte("file content")
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			{
+				t:              t,
+				Method:         "GET",
+				Host:           "www.dropbox.com",
+				Path:           "/s/2sn712vy1ovegw8/example.txt",
+				Params:         map[string]string{"dl": "1"},
+				StatusCode:     http.StatusFound,
+				ResponseHeader: http.Header{"Location": {"https://dl.dropboxusercontent.com/s/2sn712vy1ovegw8/example.txt"}},
+			},
+			{
+				t:            t,
+				Method:       "GET",
+				Host:         "dl.dropboxusercontent.com",
+				Path:         "/s/2sn712vy1ovegw8/example.txt",
+				ResponseData: content,
+			},
+		}},
+	}
+	if body, size, err = db.DownloadSharedLink("https://www.dropbox.com/s/2sn712vy1ovegw8/example.txt?dl=0&raw=1", ""); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	defer body.Close()
+	if received, _ := ioutil.ReadAll(body); !bytes.Equal(received, content) || size != int64(len(content)) {
+		t.Errorf("got %q (%d bytes) expected %q", received, size, content)
+	}
+
+	if _, _, err = db.DownloadSharedLink("https://www.dropbox.com/s/2sn712vy1ovegw8/example.txt", "secret"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("got %v expected ErrNotSupported", err)
+	}
+}
+
+func TestMediaToFile(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var index int
+	var tmpdir string
+
+	content := []byte("file content")
+	link := Link{Expires: DBTime(time.Date(2011, time.August, 10, 18, 21, 30, 0, time.UTC)), URL: "https://dl.dropboxusercontent.com/1/view/abcdefghijk/example"}
+	js, err := json.Marshal(link)
+	if err != nil {
+		t.Fatalf("could not run test due to marshalling issue: %s", err)
+	}
+	media := FakeHTTP{
+		t:            t,
+		Method:       "POST",
+		Host:         "api.dropbox.com",
+		Path:         "/1/media/auto/dummyfile",
+		Params:       map[string]string{"locale": "en"},
+		ResponseData: js,
+	}
+	fetch := FakeHTTP{
+		t:            t,
+		Method:       "GET",
+		Host:         "dl.dropboxusercontent.com",
+		Path:         "/1/view/abcdefghijk/example",
+		ResponseData: content,
+	}
+
+	if tmpdir, err = ioutil.TempDir("", "dropbox"); err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dst := filepath.Join(tmpdir, "dummyfile")
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{media, fetch}},
+	}
+	if err = db.MediaToFile("dummyfile", dst); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if received, _ := ioutil.ReadFile(dst); !bytes.Equal(received, content) {
+		t.Errorf("got %q expected %q", received, content)
+	}
+
+	index = 0
+	fetch.StatusCode = http.StatusNotFound
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{media, fetch}},
+	}
+	if err = db.MediaToFile("dummyfile", filepath.Join(tmpdir, "expired")); err == nil {
+		t.Errorf("an expired link should be reported")
+	}
+	if _, err = os.Stat(filepath.Join(tmpdir, "expired")); !os.IsNotExist(err) {
+		t.Errorf("no file should be created for an expired link")
+	}
+}
+
 func TestMetadata(t *testing.T) {
 	var err error
 	var db *Dropbox
@@ -491,13 +1036,64 @@ func TestMetadata(t *testing.T) {
 	} else if !reflect.DeepEqual(expected, *received) {
 		t.Errorf("got %#v expected %#v", *received, expected)
 	}
+
+	fake.Params["file_limit"] = "10000"
+	fake.Params["include_media_info"] = "true"
+	if received, err = db.MetadataOpts(path, MetadataOptions{List: true, IncludeDeleted: true, Hash: "6789", Rev: "12345", IncludeMediaInfo: true}); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
 }
 
-func TestMove(t *testing.T) {
-	var err error
-	var db *Dropbox
-	var received *Entry
-	var from, to string
+// largeListing returns the JSON reply of Metadata for a directory with n files.
+func largeListing(n int) []byte {
+	entry := Entry{Path: "/testdir", IsDir: true, Contents: make([]Entry, n)}
+	for i := range entry.Contents {
+		entry.Contents[i] = fileEntry
+		entry.Contents[i].Path = fmt.Sprintf("/testdir/file%d", i)
+	}
+	js, _ := json.Marshal(entry)
+	return js
+}
+
+// BenchmarkMetadataDecode compares decoding a large listing from the reply as doRequest does
+// with reading the whole reply before unmarshalling it.
+func BenchmarkMetadataDecode(b *testing.B) {
+	js := largeListing(25000)
+	db := NewDropbox()
+
+	b.Run("ReadAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var entry Entry
+
+			body, err := ioutil.ReadAll(bytes.NewReader(js))
+			if err == nil {
+				err = json.Unmarshal(body, &entry)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Decoder", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var entry Entry
+
+			if err := db.decodeJSON(bytes.NewReader(js), &entry); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestMove(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Entry
+	var from, to string
 
 	expected := fileEntry
 	from = expected.Path[1:]
@@ -532,6 +1128,277 @@ func TestMove(t *testing.T) {
 	}
 }
 
+func TestRename(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Entry
+
+	db = newDropbox(t)
+	fake := FakeHTTP{
+		t:      t,
+		Method: "POST",
+		Host:   "api.dropbox.com",
+		Path:   "/1/fileops/move",
+		Params: map[string]string{
+			"root":      "auto",
+			"from_path": "a/foo.txt",
+			"to_path":   "a/bar.txt",
+			"locale":    "en",
+		},
+		ResponseData: []byte(`{"path": "/a/bar.txt"}`),
+	}
+	http.DefaultClient = &http.Client{Transport: fake}
+	for _, src := range []string{"a/foo.txt", "/a/foo.txt", "//a//foo.txt"} {
+		if received, err = db.Rename(src, "bar.txt"); err != nil {
+			t.Errorf("%s: API error: %s", src, err)
+		} else if received.Path != "/a/bar.txt" {
+			t.Errorf("%s: got %s expected /a/bar.txt", src, received.Path)
+		}
+	}
+
+	fake.Params["from_path"] = "foo.txt"
+	fake.Params["to_path"] = "bar.txt"
+	http.DefaultClient = &http.Client{Transport: fake}
+	if _, err = db.Rename("/foo.txt", "bar.txt"); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+
+	http.DefaultClient = &http.Client{Transport: failingHTTP{}}
+	for _, name := range []string{"b/bar.txt", "/bar.txt", "", "..", "."} {
+		if _, err = db.Rename("a/foo.txt", name); err == nil || strings.Contains(err.Error(), "request sent") {
+			t.Errorf("%q: got %v expected the name to be rejected", name, err)
+		}
+	}
+	if _, err = db.Rename("/", "bar.txt"); err == nil || strings.Contains(err.Error(), "request sent") {
+		t.Errorf("got %v expected the root to be rejected", err)
+	}
+}
+
+func TestMoveMkdir(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Entry
+	var index int
+
+	from := "testfile"
+	to := "a/b/c/testfile"
+	expected := fileEntry
+	expected.Path = "/" + to
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	move := FakeHTTP{
+		t:      t,
+		Method: "POST",
+		Host:   "api.dropbox.com",
+		Path:   "/1/fileops/move",
+		Params: map[string]string{
+			"root":      "auto",
+			"from_path": from,
+			"to_path":   to,
+			"locale":    "en",
+		},
+		StatusCode:   http.StatusNotFound,
+		ResponseData: []byte(`{"error": "Parent folder of destination not found"}`),
+	}
+	mkdir := func(path string, status int) FakeHTTP {
+		return FakeHTTP{
+			t:      t,
+			Method: "POST",
+			Host:   "api.dropbox.com",
+			Path:   "/1/fileops/create_folder",
+			Params: map[string]string{
+				"root":   "auto",
+				"path":   path,
+				"locale": "en",
+			},
+			StatusCode:   status,
+			ResponseData: []byte(`{}`),
+		}
+	}
+	moved := move
+	moved.StatusCode = http.StatusOK
+	moved.ResponseData = js
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			move,
+			mkdir("a", http.StatusOK),
+			mkdir("a/b", http.StatusOK),
+			mkdir("a/b/c", http.StatusOK),
+			moved,
+		}},
+	}
+	if received, err = db.MoveMkdir(from, to); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+	if index != 5 {
+		t.Errorf("got %d requests expected 5", index)
+	}
+
+	index = 0
+	metadata := FakeHTTP{
+		t:            t,
+		Method:       "GET",
+		Host:         "api.dropbox.com",
+		Path:         "/1/metadata/auto/a",
+		Params:       map[string]string{"list": "false", "include_deleted": "false", "file_limit": "10000", "locale": "en"},
+		StatusCode:   http.StatusNotFound,
+		ResponseData: []byte(`{"error": "Path '/a' not found"}`),
+	}
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			move,
+			mkdir("a", http.StatusForbidden),
+			metadata,
+		}},
+	}
+	if _, err = db.MoveMkdir(from, to); err == nil || !isParentNotFound(err) {
+		t.Errorf("got %v expected the error of the move", err)
+	}
+}
+
+func TestSafeMove(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Entry
+	var index int
+	var warnings []string
+
+	expected := fileEntry
+	expected.Path = "/shared/testfile"
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+	op := func(path string, params map[string]string, status int, response string) FakeHTTP {
+		params["root"] = "auto"
+		params["locale"] = "en"
+		return FakeHTTP{
+			t:            t,
+			Method:       "POST",
+			Host:         "api.dropbox.com",
+			Path:         path,
+			Params:       params,
+			StatusCode:   status,
+			ResponseData: []byte(response),
+		}
+	}
+	fromTo := func() map[string]string {
+		return map[string]string{"from_path": "testfile", "to_path": "shared/testfile"}
+	}
+	refused := op("/1/fileops/move", fromTo(), http.StatusForbidden, `{"error": "Cannot move files across namespaces"}`)
+
+	db = newDropbox(t)
+	db.Warnf = func(format string, v ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, v...))
+	}
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			refused,
+			op("/1/fileops/copy", fromTo(), http.StatusOK, string(js)),
+			op("/1/fileops/delete", map[string]string{"path": "testfile"}, http.StatusOK, `{"path": "/testfile", "is_deleted": true}`),
+		}},
+	}
+	if received, err = db.SafeMove("testfile", "shared/testfile"); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+	if index != 3 || len(warnings) != 1 {
+		t.Errorf("got %d requests and %d warnings expected 3 and 1", index, len(warnings))
+	}
+
+	// The source must not be deleted when the copy failed.
+	index = 0
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			refused,
+			op("/1/fileops/copy", fromTo(), http.StatusInsufficientStorage, `{"error": "Over quota"}`),
+		}},
+	}
+	if _, err = db.SafeMove("testfile", "shared/testfile"); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("got %v expected the error of the copy", err)
+	}
+	if index != 2 {
+		t.Errorf("got %d requests expected 2", index)
+	}
+}
+
+func TestEnsureFolder(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Entry
+	var index int
+
+	expected := dirEntry
+	expected.Path = "/a/b"
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	mkdir := func(path string, status int, response string) FakeHTTP {
+		return FakeHTTP{
+			t:            t,
+			Method:       "POST",
+			Host:         "api.dropbox.com",
+			Path:         "/1/fileops/create_folder",
+			Params:       map[string]string{"root": "auto", "path": path, "locale": "en"},
+			StatusCode:   status,
+			ResponseData: []byte(response),
+		}
+	}
+	metadata := FakeHTTP{
+		t:            t,
+		Method:       "GET",
+		Host:         "api.dropbox.com",
+		Path:         "/1/metadata/auto/a/b",
+		Params:       map[string]string{"list": "false", "include_deleted": "false", "file_limit": "10000", "locale": "en"},
+		ResponseData: js,
+	}
+	exists := `{"error": "A file or folder already exists at path '/a/b'."}`
+	notFound := `{"error": "Parent folder not found"}`
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			mkdir("a/b", http.StatusNotFound, notFound),
+			mkdir("a", http.StatusOK, `{"is_dir": true, "path": "/a"}`),
+			mkdir("a/b", http.StatusOK, string(js)),
+			mkdir("a/b", http.StatusForbidden, exists),
+			metadata,
+		}},
+	}
+	for i := 0; i < 2; i++ {
+		if received, err = db.EnsureFolder("/a/b"); err != nil {
+			t.Errorf("API error: %s", err)
+		} else if !reflect.DeepEqual(expected, *received) {
+			t.Errorf("got %#v expected %#v", *received, expected)
+		}
+	}
+	if index != 5 {
+		t.Errorf("got %d requests expected 5", index)
+	}
+
+	index = 0
+	metadata.ResponseData, _ = json.Marshal(fileEntry)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			mkdir("a/b", http.StatusForbidden, exists),
+			metadata,
+		}},
+	}
+	if _, err = db.EnsureFolder("a/b"); !errors.Is(err, ErrTargetExists) {
+		t.Errorf("got %v expected ErrTargetExists", err)
+	}
+}
+
 func TestRestore(t *testing.T) {
 	var err error
 	var db *Dropbox
@@ -567,6 +1434,61 @@ func TestRestore(t *testing.T) {
 	}
 }
 
+func TestRestoreToPath(t *testing.T) {
+	var err error
+	var received *Entry
+	var index int
+
+	old := "1f33043551e"
+	current := fileEntry
+	expected := fileEntry
+	expected.Path = "/old"
+	currentJS, _ := json.Marshal(current)
+	expectedJS, _ := json.Marshal(expected)
+
+	restore := func(rev string) FakeHTTP {
+		return FakeHTTP{
+			t:            t,
+			Method:       "POST",
+			Host:         "api.dropbox.com",
+			Path:         "/1/restore/auto/testfile",
+			Params:       map[string]string{"rev": rev, "locale": "en"},
+			ResponseData: currentJS,
+		}
+	}
+	db := newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			{
+				t:            t,
+				Method:       "GET",
+				Host:         "api.dropbox.com",
+				Path:         "/1/metadata/auto/testfile",
+				Params:       map[string]string{"list": "false", "include_deleted": "true", "file_limit": "10000", "locale": "en"},
+				ResponseData: currentJS,
+			},
+			restore(old),
+			{
+				t:            t,
+				Method:       "POST",
+				Host:         "api.dropbox.com",
+				Path:         "/1/fileops/copy",
+				Params:       map[string]string{"root": "auto", "from_path": "testfile", "to_path": "old", "locale": "en"},
+				ResponseData: expectedJS,
+			},
+			restore(current.Revision),
+		}},
+	}
+	if received, err = db.RestoreToPath("testfile", old, "old"); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+	if index != 4 {
+		t.Errorf("got %d requests expected 4", index)
+	}
+}
+
 func TestRevisions(t *testing.T) {
 	var err error
 	var db *Dropbox
@@ -619,6 +1541,57 @@ func TestRevisions(t *testing.T) {
 	}
 }
 
+func TestRevisionsSorted(t *testing.T) {
+	var err error
+	var received []Entry
+	var warnings []string
+
+	revision := func(rev string, modified time.Time) Entry {
+		e := fileEntry
+		e.Revision = rev
+		e.Modified = DBTime(modified)
+		return e
+	}
+	day := time.Date(2011, time.August, 10, 18, 21, 30, 0, time.UTC)
+	js, err := json.Marshal([]Entry{
+		revision("1", day),
+		revision("2", time.Time{}),
+		revision("3", day.AddDate(0, 0, 2)),
+		revision("4", day.AddDate(0, 0, 1)),
+	})
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db := newDropbox(t)
+	db.Warnf = func(format string, v ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, v...))
+	}
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/revisions/auto/testfile",
+			Params:       map[string]string{"rev_limit": "10", "locale": "en"},
+			ResponseData: js,
+		},
+	}
+	if received, err = db.RevisionsSorted("testfile", 10); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	var revs []string
+	for _, e := range received {
+		revs = append(revs, e.Revision)
+	}
+	if expected := []string{"3", "4", "1", "2"}; !reflect.DeepEqual(revs, expected) {
+		t.Errorf("got %v expected %v", revs, expected)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "revision 2") {
+		t.Errorf("got warnings %q expected one for revision 2", warnings)
+	}
+}
+
 func TestSearch(t *testing.T) {
 	var err error
 	var db *Dropbox
@@ -680,6 +1653,90 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchWithLimit(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received []Entry
+	var truncated bool
+
+	db = newDropbox(t)
+
+	expected := make([]Entry, 5)
+	for i := range expected {
+		expected[i] = Entry{Path: fmt.Sprintf("/dummy/file%d", i), Root: "auto"}
+	}
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test due to marshalling issue")
+	}
+
+	fake := FakeHTTP{
+		Method: "GET",
+		Host:   "api.dropbox.com",
+		Path:   "/1/search/auto/dummy",
+		t:      t,
+		Params: map[string]string{
+			"locale":          "en",
+			"query":           "file",
+			"file_limit":      "5",
+			"include_deleted": "false",
+		},
+		ResponseData: js,
+	}
+	http.DefaultClient = &http.Client{
+		Transport: fake,
+	}
+
+	if received, truncated, err = db.SearchWithLimit("dummy", "file", 5, false); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, received) {
+		t.Errorf("got %#v expected %#v", received, expected)
+	} else if !truncated {
+		t.Errorf("results should be reported as truncated")
+	}
+
+	fake.Params["file_limit"] = "6"
+	if _, truncated, err = db.SearchWithLimit("dummy", "file", 6, false); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if truncated {
+		t.Errorf("results should not be reported as truncated")
+	}
+}
+
+func TestSearchDetailed(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *SearchResult
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			Method: "GET",
+			Host:   "api.dropbox.com",
+			Path:   "/1/search/auto/dummy",
+			t:      t,
+			Params: map[string]string{
+				"locale":          "en",
+				"query":           "file",
+				"file_limit":      "10",
+				"include_deleted": "true",
+			},
+			ResponseData: []byte(`[{"path": "/dummy/file3", "is_deleted": true}, {"path": "/dummy/file2"},
+				{"path": "/dummy/file1", "is_deleted": true}, {"path": "/dummy/file0"}]`),
+		},
+	}
+
+	expected := &SearchResult{
+		Matches: []SearchMatch{{Entry: Entry{Path: "/dummy/file0"}}, {Entry: Entry{Path: "/dummy/file2"}}},
+		Deleted: []SearchMatch{{Entry: Entry{Path: "/dummy/file1", IsDeleted: true}}, {Entry: Entry{Path: "/dummy/file3", IsDeleted: true}}},
+	}
+	if received, err = db.SearchDetailed("dummy", "file", 10, true); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, received) {
+		t.Errorf("got %#v expected %#v", received, expected)
+	}
+}
+
 func TestShares(t *testing.T) {
 	var err error
 	var db *Dropbox
@@ -784,3 +1841,1712 @@ func TestLatestCursor(t *testing.T) {
 		}
 	}
 }
+
+func TestEntryIsFile(t *testing.T) {
+	tab := []struct {
+		entry  Entry
+		isFile bool
+	}{
+		{fileEntry, true},
+		{dirEntry, false},
+		{Entry{Path: "/deleted", IsDeleted: true}, false},
+	}
+
+	for _, tc := range tab {
+		if tc.entry.IsFile() != tc.isFile {
+			t.Errorf("%s: got %v expected %v", tc.entry.Path, !tc.isFile, tc.isFile)
+		}
+	}
+
+	var entry Entry
+	if err := json.Unmarshal([]byte(`{"path": "/big.iso", "bytes": 5368709120}`), &entry); err != nil {
+		t.Fatalf("could not unmarshal entry: %s", err)
+	}
+	if entry.SizeBytes() != 5*1024*1024*1024 {
+		t.Errorf("got %d expected %d", entry.SizeBytes(), int64(5*1024*1024*1024))
+	}
+}
+
+func TestEntryTimes(t *testing.T) {
+	var entry Entry
+	var received time.Time
+	var err error
+
+	js := []byte(`{"path": "/testfile", "modified": "Wed, 10 Aug 2011 18:21:30 +0000", "client_mtime": ""}`)
+	if err = json.Unmarshal(js, &entry); err != nil {
+		t.Fatalf("could not unmarshal entry: %s", err)
+	}
+
+	expected := time.Date(2011, time.August, 10, 18, 21, 30, 0, time.UTC)
+	if received, err = entry.ModifiedTime(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if !received.Equal(expected) {
+		t.Errorf("got %s expected %s", received, expected)
+	}
+
+	if _, err = entry.ClientMtimeTime(); !errors.Is(err, ErrNoTimestamp) {
+		t.Errorf("got %v expected ErrNoTimestamp", err)
+	}
+}
+
+func TestExpiresTime(t *testing.T) {
+	var received time.Time
+	var err error
+
+	expected := time.Date(2042, time.January, 31, 21, 1, 5, 0, time.UTC)
+	ref := CopyRef{CopyRef: "z1X6ATl6aWtzOGq0c3g5Ng", Expires: "Fri, 31 Jan 2042 21:01:05 +0000"}
+	if received, err = ref.ExpiresTime(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if !received.Equal(expected) {
+		t.Errorf("got %s expected %s", received, expected)
+	}
+
+	ref.Expires = ""
+	if received, err = ref.ExpiresTime(); err != nil || !received.IsZero() {
+		t.Errorf("got %s, %v expected the zero time", received, err)
+	}
+
+	ref.Expires = "tomorrow"
+	if _, err = ref.ExpiresTime(); err == nil {
+		t.Errorf("a malformed date should be rejected")
+	}
+
+	link := Link{Expires: DBTime(expected)}
+	if received, err = link.ExpiresTime(); err != nil || !received.Equal(expected) {
+		t.Errorf("got %s, %v expected %s", received, err, expected)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var ae *APIError
+
+	db = newDropbox(t)
+	fake := FakeHTTP{
+		t:            t,
+		Method:       "GET",
+		Host:         "api.dropbox.com",
+		Path:         "/1/account/info",
+		Params:       map[string]string{"locale": "en"},
+		ResponseData: []byte(`{"error": {"locale": "unknown locale"}}`),
+		StatusCode:   http.StatusBadRequest,
+	}
+	http.DefaultClient = &http.Client{
+		Transport: fake,
+	}
+
+	_, err = db.GetAccountInfo()
+	if !errors.As(err, &ae) {
+		t.Fatalf("got %#v expected an *APIError", err)
+	}
+	if ae.StatusCode != http.StatusBadRequest || ae.Param != "locale" || ae.Reason != "unknown locale" {
+		t.Errorf("got %#v", ae)
+	}
+
+	fake.StatusCode = http.StatusNotFound
+	fake.ResponseData = []byte(`{"error": "Path not found"}`)
+	http.DefaultClient = &http.Client{
+		Transport: fake,
+	}
+	if _, err = db.GetAccountInfo(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %#v expected os.ErrNotExist", err)
+	}
+
+	fake.StatusCode = http.StatusUnauthorized
+	fake.ResponseData = nil
+	http.DefaultClient = &http.Client{
+		Transport: fake,
+	}
+	if _, err = db.GetAccountInfo(); !errors.Is(err, ErrNotAuth) {
+		t.Errorf("got %#v expected ErrNotAuth", err)
+	}
+}
+
+type retryHTTP struct {
+	FakeHTTP
+	failures *int
+}
+
+func (f retryHTTP) RoundTrip(req *http.Request) (*http.Response, error) {
+	if *f.failures > 0 {
+		*f.failures--
+		return &http.Response{Status: "429 Too Many Requests", StatusCode: http.StatusTooManyRequests,
+			Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+			Header: http.Header{"Retry-After": {"0"}},
+			Body:   ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+	return f.FakeHTTP.RoundTrip(req)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Entry
+	var failures int
+	var content []byte
+
+	expected := fileEntry
+	content = []byte("file content")
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	db.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	http.DefaultClient = &http.Client{
+		Transport: retryHTTP{
+			FakeHTTP: FakeHTTP{
+				t:      t,
+				Method: "PUT",
+				Host:   "api-content.dropbox.com",
+				Path:   "/1/files_put/auto/testfile",
+				Params: map[string]string{
+					"locale":    "en",
+					"overwrite": "false",
+				},
+				RequestData:  content,
+				ResponseData: js,
+			},
+			failures: &failures,
+		},
+	}
+
+	failures = 2
+	received, err = db.FilesPut(ioutil.NopCloser(bytes.NewReader(content)), int64(len(content)), "testfile", false, "")
+	if err == nil {
+		t.Errorf("a non seekable body must not be retried")
+	}
+
+	failures = 2
+	received, err = db.FilesPut(readSeekCloser{bytes.NewReader(content)}, int64(len(content)), "testfile", false, "")
+	if err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+
+	failures = 3
+	if _, err = db.FilesPut(readSeekCloser{bytes.NewReader(content)}, int64(len(content)), "testfile", false, ""); err == nil {
+		t.Errorf("request must fail after %d attempts", db.RetryPolicy.MaxAttempts)
+	}
+}
+
+func TestRateLimitError(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var rle *RateLimitError
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:              t,
+			Method:         "GET",
+			Host:           "api.dropbox.com",
+			Path:           "/1/account/info",
+			Params:         map[string]string{"locale": "en"},
+			StatusCode:     http.StatusTooManyRequests,
+			ResponseHeader: http.Header{"Retry-After": {"15"}},
+			ResponseData:   []byte("Too many requests"),
+		},
+	}
+	_, err = db.GetAccountInfo()
+	if !errors.As(err, &rle) {
+		t.Fatalf("got %#v expected a *RateLimitError", err)
+	}
+	if rle.RetryAfter != 15*time.Second {
+		t.Errorf("got %s expected 15s", rle.RetryAfter)
+	}
+
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:          t,
+			Method:     "GET",
+			Host:       "api-content.dropbox.com",
+			Path:       "/1/files/auto/testfile",
+			StatusCode: http.StatusTooManyRequests,
+		},
+	}
+	_, _, err = db.Download("testfile", "", 0)
+	if !errors.As(err, &rle) {
+		t.Fatalf("got %#v expected a *RateLimitError", err)
+	}
+	if rle.RetryAfter != 0 {
+		t.Errorf("got %s expected 0 without Retry-After", rle.RetryAfter)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var ne net.Error
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		w.Write([]byte(`{"uid": 12345678}`))
+	}))
+	defer server.Close()
+
+	db = newDropbox(t)
+	db.APIURL = server.URL
+	db.HTTPClient = &http.Client{Transport: &http.Transport{}}
+	db.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err = db.GetAccountInfo()
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("got %v expected a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("request was not interrupted after %s", elapsed)
+	}
+}
+
+func TestSetBaseURLs(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Account
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/account/info" {
+			t.Errorf("wrong URL %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"uid": 12345678}`))
+	}))
+	defer server.Close()
+
+	db = newDropbox(t)
+	db.HTTPClient = server.Client()
+	if err = db.SetBaseURLs(server.URL+"/1/", server.URL+"/1", server.URL+"/1"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if db.APIURL != server.URL+"/1" {
+		t.Errorf("got %s expected %s", db.APIURL, server.URL+"/1")
+	}
+	if received, err = db.GetAccountInfo(); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if received.UID != 12345678 {
+		t.Errorf("got %d expected 12345678", received.UID)
+	}
+
+	for _, invalid := range []string{"", "/1", "localhost:8080/1", "http://%zz"} {
+		if err = db.SetBaseURLs(invalid, server.URL, server.URL); err == nil {
+			t.Errorf("%q should be rejected", invalid)
+		}
+	}
+	if db.APIURL != server.URL+"/1" {
+		t.Errorf("a failed call must not change the URLs")
+	}
+}
+
+type readSeekCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekCloser) Close() error {
+	return nil
+}
+
+type countingLimiter struct {
+	calls int
+	err   error
+}
+
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	l.calls++
+	return l.err
+}
+
+func TestRateLimit(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var failures int
+
+	db = newDropbox(t)
+	db.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	limiter := &countingLimiter{}
+	db.RateLimit = limiter
+	http.DefaultClient = &http.Client{
+		Transport: retryHTTP{
+			FakeHTTP: FakeHTTP{
+				t:            t,
+				Method:       "GET",
+				Host:         "api.dropbox.com",
+				Path:         "/1/account/info",
+				Params:       map[string]string{"locale": "en"},
+				ResponseData: []byte(`{"uid": 12345678}`),
+			},
+			failures: &failures,
+		},
+	}
+
+	failures = 1
+	if _, err = db.GetAccountInfo(); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+	if limiter.calls != 2 {
+		t.Errorf("got %d calls to Wait expected 2", limiter.calls)
+	}
+
+	limiter.err = errors.New("rate: Wait(n=1) would exceed context deadline")
+	if _, err = db.GetAccountInfo(); err != limiter.err {
+		t.Errorf("got %v expected %v", err, limiter.err)
+	}
+}
+
+func TestPathRoot(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var body io.ReadCloser
+
+	expected := `{".tag":"namespace_id","namespace_id":"1234"}`
+	if received := NamespacePathRoot("1234"); received != expected {
+		t.Errorf("got %s expected %s", received, expected)
+	}
+
+	db = newDropbox(t)
+	db.PathRoot = NamespacePathRoot("1234")
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/account/info",
+			Params:       map[string]string{"locale": "en"},
+			Headers:      map[string]string{"Dropbox-API-Path-Root": expected},
+			ResponseData: []byte(`{"uid": 12345678}`),
+		},
+	}
+	if _, err = db.GetAccountInfo(); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api-content.dropbox.com",
+			Path:         "/1/files/auto/testfile",
+			Headers:      map[string]string{"Dropbox-API-Path-Root": expected},
+			ResponseData: []byte("file content"),
+		},
+	}
+	if body, _, err = db.Download("testfile", "", 0); err != nil {
+		t.Errorf("API error: %s", err)
+	} else {
+		body.Close()
+	}
+}
+
+func TestSetRoot(t *testing.T) {
+	db := newDropbox(t)
+	for _, root := range []Root{RootAuto, RootDropbox, RootSandbox} {
+		if err := db.SetRoot(root); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if db.RootDirectory != string(root) {
+			t.Errorf("got %s expected %s", db.RootDirectory, root)
+		}
+	}
+
+	for _, root := range []Root{"", "Dropbox", "app_folder"} {
+		if err := db.SetRoot(root); err == nil {
+			t.Errorf("root '%s' should be rejected", root)
+		}
+	}
+	if db.RootDirectory != RootSandbox {
+		t.Errorf("got %s expected the root to be unchanged", db.RootDirectory)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var infos []RequestInfo
+
+	db := newDropbox(t)
+	db.Trace = func(info RequestInfo) {
+		infos = append(infos, info)
+	}
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/account/info",
+			Params:       map[string]string{"locale": "en"},
+			ResponseData: []byte(`{"uid": 12345678}`),
+		},
+	}
+	if _, err := db.GetAccountInfo(); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("got %d traces expected 1", len(infos))
+	}
+	if infos[0].Method != "GET" || infos[0].URL != "https://api.dropbox.com/1/account/info?locale=en" || infos[0].StatusCode != http.StatusOK {
+		t.Errorf("got %#v expected the trace of the request", infos[0])
+	}
+
+	request, _ := http.NewRequest("GET", "https://api.dropbox.com/1/account/info?access_token=secret&locale=en", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	db.trace(request, nil, errors.New("failed"), time.Now())
+	if len(infos) != 2 {
+		t.Fatalf("got %d traces expected 2", len(infos))
+	}
+	if strings.Contains(infos[1].URL, "secret") || strings.Contains(infos[1].Header.Get("Authorization"), "secret") {
+		t.Errorf("the access token was not redacted: %#v", infos[1])
+	}
+	if request.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("the request must not be modified")
+	}
+}
+
+func TestSetLocale(t *testing.T) {
+	db := newDropbox(t)
+	for _, locale := range []string{"fr", "pt-BR", "zh_TW", "en"} {
+		if err := db.SetLocale(locale); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if db.Locale != locale {
+			t.Errorf("got %s expected %s", db.Locale, locale)
+		}
+	}
+
+	for _, locale := range []string{"", "english", "e", "en-", "en US", "fr/FR"} {
+		if err := db.SetLocale(locale); err == nil {
+			t.Errorf("locale '%s' should be rejected", locale)
+		}
+	}
+	if db.Locale != "en" {
+		t.Errorf("got %s expected the locale to be unchanged", db.Locale)
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Account
+
+	expected := Account{DisplayName: "John P. User", UID: 12345678}
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	db.HTTPClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/account/info",
+			Params:       map[string]string{"locale": "en"},
+			ResponseData: js,
+		},
+	}
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{t: t},
+	}
+
+	if received, err = db.GetAccountInfo(); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var content, js []byte
+	var transferred, total int64
+	var tmpdir string
+
+	content = []byte("file content")
+	progress := func(n, size int64) {
+		transferred, total = n, size
+	}
+	js, err = json.Marshal(fileEntry)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "PUT",
+			Host:   "api-content.dropbox.com",
+			Path:   "/1/files_put/auto/testfile",
+			Params: map[string]string{
+				"locale":    "en",
+				"overwrite": "false",
+			},
+			RequestData:  content,
+			ResponseData: js,
+		},
+	}
+	if _, err = db.FilesPutProgress(ioutil.NopCloser(bytes.NewReader(content)), int64(len(content)), "testfile", false, "", progress); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if transferred != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("got %d/%d expected %d/%d", transferred, total, len(content), len(content))
+	}
+
+	if tmpdir, err = ioutil.TempDir("", "dropbox"); err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	transferred, total = 0, 0
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api-content.dropbox.com",
+			Path:         "/1/files/auto/testfile",
+			ResponseData: content,
+		},
+	}
+	if err = db.DownloadToFileProgress("testfile", filepath.Join(tmpdir, "testfile"), "", progress); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if transferred != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("got %d/%d expected %d/%d", transferred, total, len(content), len(content))
+	}
+}
+
+// pagesHTTP replies successively with each of the given FakeHTTP.
+// If block is true, the requests sent once all pages have been used wait for their context to be done.
+type pagesHTTP struct {
+	pages []FakeHTTP
+	index *int
+	block bool
+}
+
+func (p pagesHTTP) RoundTrip(req *http.Request) (*http.Response, error) {
+	if *p.index >= len(p.pages) && p.block {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	if *p.index >= len(p.pages) {
+		return nil, fmt.Errorf("unexpected request %s", req.URL)
+	}
+	*p.index++
+	return p.pages[*p.index-1].RoundTrip(req)
+}
+
+func TestDeltaIterator(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var index int
+	var received []string
+	var entry *DeltaEntry
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{
+			index: &index,
+			pages: []FakeHTTP{
+				{
+					t:            t,
+					Method:       "POST",
+					Host:         "api.dropbox.com",
+					Path:         "/1/delta",
+					Params:       map[string]string{"locale": "en", "cursor": "first"},
+					ResponseData: []byte(`{"reset": true, "has_more": true, "cursor": "second", "entries": [["/testfile", null], ["/testdir", null]]}`),
+				},
+				{
+					t:            t,
+					Method:       "POST",
+					Host:         "api.dropbox.com",
+					Path:         "/1/delta",
+					Params:       map[string]string{"locale": "en", "cursor": "second"},
+					ResponseData: []byte(`{"reset": false, "has_more": false, "cursor": "last", "entries": [["/otherfile", null]]}`),
+				},
+			},
+		},
+	}
+
+	it := db.DeltaIterator("first", "")
+	for entry, err = it.Next(); err == nil; entry, err = it.Next() {
+		received = append(received, entry.Path)
+	}
+	if err != io.EOF {
+		t.Errorf("API error: %s", err)
+	}
+	expected := []string{"/testfile", "/testdir", "/otherfile"}
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("got %#v expected %#v", received, expected)
+	}
+	if !it.ShouldReset() {
+		t.Errorf("reset was not reported")
+	}
+	if it.Cursor() != "last" {
+		t.Errorf("got cursor %s expected last", it.Cursor())
+	}
+}
+
+func TestDeltaMalformed(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *DeltaPage
+	var warnings []string
+	var mde *MalformedDeltaError
+
+	db = newDropbox(t)
+	db.Warnf = func(format string, v ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, v...))
+	}
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "POST",
+			Host:   "api.dropbox.com",
+			Path:   "/1/delta",
+			Params: map[string]string{"locale": "en"},
+			ResponseData: []byte(`{"reset": false, "has_more": false, "cursor": "next", "entries": [
+				["/a.txt", {"path": "/a.txt"}],
+				["/bad.txt"],
+				["/b.txt", null]]}`),
+		},
+	}
+
+	if received, err = db.Delta("", ""); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if len(received.Entries) != 2 || received.Entries[0].Path != "/a.txt" || received.Entries[1].Path != "/b.txt" {
+		t.Errorf("got %#v expected the valid entries", received.Entries)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `["/bad.txt"]`) {
+		t.Errorf("got warnings %q expected one for the bad entry", warnings)
+	}
+
+	db.StrictDelta = true
+	if _, err = db.Delta("", ""); !errors.Is(err, ErrMalformedDelta) || !errors.As(err, &mde) {
+		t.Fatalf("got %v expected ErrMalformedDelta", err)
+	}
+	if string(mde.Raw) != `["/bad.txt"]` {
+		t.Errorf("got %s expected the raw entry", mde.Raw)
+	}
+}
+
+func TestDeltaMediaInfo(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *DeltaPage
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "POST",
+			Host:   "api.dropbox.com",
+			Path:   "/1/delta",
+			Params: map[string]string{"locale": "en", "path_prefix": "/photos", "include_media_info": "true"},
+			ResponseData: []byte(`{"reset": false, "has_more": false, "cursor": "next", "entries": [
+				["/photos/beach.jpg", {"path": "/photos/beach.jpg", "photo_info": {"lat_long": [37.77, -122.41], "time_taken": "Wed, 28 Aug 2013 18:12:02 +0000"}}],
+				["/photos/party.mp4", {"path": "/photos/party.mp4", "video_info": {"time_taken": "Wed, 28 Aug 2013 18:12:02 +0000", "duration": 5000}}],
+				["/photos/new.jpg", {"path": "/photos/new.jpg", "photo_info": "pending"}]]}`),
+		},
+	}
+
+	if received, err = db.DeltaWithOptions("", DeltaOptions{PathPrefix: "/photos", IncludeMediaInfo: true}); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if len(received.Entries) != 3 {
+		t.Fatalf("got %d entries expected 3", len(received.Entries))
+	}
+	taken := DBTime(time.Date(2013, time.August, 28, 18, 12, 2, 0, time.UTC))
+	expected := []*MediaInfo{
+		{LatLong: []float64{37.77, -122.41}, TimeTaken: taken},
+		{TimeTaken: taken, Duration: 5000},
+		{Pending: true},
+	}
+	for i, e := range received.Entries {
+		info := e.Entry.PhotoInfo
+		if info == nil {
+			info = e.Entry.VideoInfo
+		}
+		if !reflect.DeepEqual(expected[i], info) {
+			t.Errorf("%s: got %#v expected %#v", e.Path, info, expected[i])
+		}
+	}
+
+	js, err := json.Marshal(received.Entries[2].Entry)
+	if err != nil || !strings.Contains(string(js), `"photo_info":"pending"`) {
+		t.Errorf("pending media info was not marshalled: %s %v", js, err)
+	}
+}
+
+func TestAccountQuota(t *testing.T) {
+	var account Account
+
+	if account.UsedFraction() != 0 {
+		t.Errorf("got %f expected 0 when quota is unknown", account.UsedFraction())
+	}
+
+	account.QuotaInfo.Quota = 1000
+	account.QuotaInfo.Normal = 200
+	account.QuotaInfo.Shared = 50
+	if account.Used() != 250 {
+		t.Errorf("got %d expected 250", account.Used())
+	}
+	if account.Free() != 750 {
+		t.Errorf("got %d expected 750", account.Free())
+	}
+	if account.UsedFraction() != 0.25 {
+		t.Errorf("got %f expected 0.25", account.UsedFraction())
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tab := []struct {
+		path     string
+		expected string
+	}{
+		{"metadata/auto/testfile", "metadata/auto/testfile"},
+		{"metadata/auto/my report #2.txt", "metadata/auto/my%20report%20%232.txt"},
+		{"metadata/auto/why?/a+b", "metadata/auto/why%3F/a+b"},
+		{"metadata/auto/été/日本", "metadata/auto/%C3%A9t%C3%A9/%E6%97%A5%E6%9C%AC"},
+	}
+
+	for _, testCase := range tab {
+		if received := escapePath(testCase.path); received != testCase.expected {
+			t.Errorf("got %s expected %s", received, testCase.expected)
+		}
+	}
+}
+
+func TestMetadataEscaping(t *testing.T) {
+	var db *Dropbox
+	var received *Entry
+
+	for _, path := range []string{"my report #2.txt", "why?/a+b%", "été/日本語.txt"} {
+		expected := fileEntry
+		expected.Path = "/" + path
+		js, err := json.Marshal(expected)
+		if err != nil {
+			t.Fatalf("could not run test marshalling issue")
+		}
+
+		db = newDropbox(t)
+		http.DefaultClient = &http.Client{
+			Transport: FakeHTTP{
+				t:      t,
+				Method: "GET",
+				Host:   "api.dropbox.com",
+				Path:   "/1/metadata/auto/" + path,
+				Params: map[string]string{
+					"list":            "false",
+					"include_deleted": "false",
+					"file_limit":      "10",
+					"locale":          "en",
+				},
+				ResponseData: js,
+			},
+		}
+		if received, err = db.Metadata(path, false, false, "", "", 10); err != nil {
+			t.Errorf("API error: %s", err)
+		} else if !reflect.DeepEqual(expected, *received) {
+			t.Errorf("got %#v expected %#v", *received, expected)
+		}
+	}
+}
+
+func TestUploadByChunk(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Entry
+	var index int
+	var content, js []byte
+
+	content = []byte("file content")
+	expected := fileEntry
+	js, err = json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	chunk := FakeHTTP{
+		t:            t,
+		Method:       "POST",
+		Host:         "api-content.dropbox.com",
+		Path:         "/1/chunked_upload",
+		RequestData:  content,
+		ResponseData: []byte(`{"upload_id": "v0k84B0AT9fYkfMUp0sBTA", "offset": 12, "expires": "Tue, 19 Jul 2011 21:55:38 +0000"}`),
+	}
+	commit := FakeHTTP{
+		t:      t,
+		Method: "POST",
+		Host:   "api-content.dropbox.com",
+		Path:   "/1/commit_chunked_upload/auto/testfile",
+		Params: map[string]string{
+			"locale":    "en",
+			"overwrite": "false",
+			"upload_id": "v0k84B0AT9fYkfMUp0sBTA",
+		},
+		ResponseData: js,
+	}
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{chunk, commit}},
+	}
+	if received, err = db.UploadByChunk(ioutil.NopCloser(bytes.NewReader(content)), 1024, "testfile", false, ""); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+	if index != 2 {
+		t.Errorf("got %d requests expected 2", index)
+	}
+
+	index = 0
+	chunk.ResponseData = []byte(`{"upload_id": "v0k84B0AT9fYkfMUp0sBTA", "offset": 5, "expires": "Tue, 19 Jul 2011 21:55:38 +0000"}`)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{chunk}},
+	}
+	if _, err = db.UploadByChunk(ioutil.NopCloser(bytes.NewReader(content)), 1024, "testfile", false, ""); err == nil {
+		t.Errorf("an offset mismatch must return an error")
+	}
+}
+
+func TestChunkedUploadLargeOffset(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *ChunkUploadResponse
+
+	content := []byte("file content")
+	offset := int64(3 * 1024 * 1024 * 1024)
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "POST",
+			Host:   "api-content.dropbox.com",
+			Path:   "/1/chunked_upload",
+			Params: map[string]string{
+				"upload_id": "v0k84B0AT9fYkfMUp0sBTA",
+				"offset":    "3221225472",
+			},
+			RequestData:  content,
+			ResponseData: []byte(`{"upload_id": "v0k84B0AT9fYkfMUp0sBTA", "offset": 3221225484}`),
+		},
+	}
+
+	session := &ChunkUploadResponse{UploadID: "v0k84B0AT9fYkfMUp0sBTA", Offset: offset}
+	received, err = db.ChunkedUpload(session, ioutil.NopCloser(bytes.NewReader(content)), 1024)
+	if err != ErrUploadComplete {
+		t.Errorf("got %v expected ErrUploadComplete", err)
+	} else if !errors.Is(err, io.EOF) {
+		t.Errorf("ErrUploadComplete should match io.EOF")
+	}
+	if received == nil || received.Offset != offset+int64(len(content)) {
+		t.Errorf("got %#v expected offset %d", received, offset+int64(len(content)))
+	}
+}
+
+func TestChunkSize(t *testing.T) {
+	db := newDropbox(t)
+
+	if received := db.chunkSize(0); received != DefaultChunkSize {
+		t.Errorf("got %d expected %d", received, DefaultChunkSize)
+	}
+	db.DefaultUploadChunkSize = 1024
+	if received := db.chunkSize(0); received != 1024 {
+		t.Errorf("got %d expected 1024", received)
+	}
+	if received := db.chunkSize(2048); received != 2048 {
+		t.Errorf("got %d expected 2048", received)
+	}
+	db.DefaultUploadChunkSize = MaxPutFileSize + 1
+	if received := db.chunkSize(-1); received != MaxPutFileSize {
+		t.Errorf("got %d expected %d", received, MaxPutFileSize)
+	}
+}
+
+func TestResumeChunkedUpload(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Entry
+	var index int
+	var content, js []byte
+
+	content = []byte("file content")
+	expected := fileEntry
+	js, err = json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{
+			index: &index,
+			pages: []FakeHTTP{
+				{
+					t:      t,
+					Method: "POST",
+					Host:   "api-content.dropbox.com",
+					Path:   "/1/chunked_upload",
+					Params: map[string]string{
+						"upload_id": "v0k84B0AT9fYkfMUp0sBTA",
+						"offset":    "4",
+					},
+					RequestData:  content[4:],
+					ResponseData: []byte(`{"upload_id": "v0k84B0AT9fYkfMUp0sBTA", "offset": 12, "expires": "Tue, 19 Jul 2011 21:55:38 +0000"}`),
+				},
+				{
+					t:      t,
+					Method: "POST",
+					Host:   "api-content.dropbox.com",
+					Path:   "/1/commit_chunked_upload/auto/testfile",
+					Params: map[string]string{
+						"locale":    "en",
+						"overwrite": "false",
+						"upload_id": "v0k84B0AT9fYkfMUp0sBTA",
+					},
+					ResponseData: js,
+				},
+			},
+		},
+	}
+
+	session := &ChunkUploadResponse{UploadID: "v0k84B0AT9fYkfMUp0sBTA", Offset: 4}
+	if received, err = db.ResumeChunkedUpload(session, bytes.NewReader(content), 1024, "testfile", false, ""); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+}
+
+func TestSaveLoadToken(t *testing.T) {
+	var err error
+	var buf bytes.Buffer
+
+	db := newDropbox(t)
+	expected := &oauth2.Token{
+		AccessToken:  "dummyoauthtoken",
+		TokenType:    "Bearer",
+		RefreshToken: "dummyrefreshtoken",
+		Expiry:       time.Date(2042, time.January, 31, 21, 1, 5, 0, time.UTC),
+	}
+	db.SetToken(expected)
+	if err = db.SaveToken(&buf); err != nil {
+		t.Fatalf("could not save token: %s", err)
+	}
+
+	db = NewDropbox()
+	if err = db.LoadToken(&buf); err != nil {
+		t.Fatalf("could not load token: %s", err)
+	}
+	if !reflect.DeepEqual(expected, db.Token()) {
+		t.Errorf("got %#v expected %#v", db.Token(), expected)
+	}
+}
+
+func TestTokenConcurrentAccess(t *testing.T) {
+	var wg sync.WaitGroup
+
+	db := newDropbox(t)
+	token := db.Token()
+	token.AccessToken = "modified"
+	if db.AccessToken() != "dummyoauthtoken" {
+		t.Errorf("Token must return a copy, got access token %q", db.AccessToken())
+	}
+
+	token = &oauth2.Token{AccessToken: "dummyoauthtoken"}
+	db.SetToken(token)
+	db.SetRefreshToken("dummyrefreshtoken")
+	if token.RefreshToken != "" || db.Token().RefreshToken != "dummyrefreshtoken" || db.AccessToken() != "dummyoauthtoken" {
+		t.Errorf("SetRefreshToken must replace the token, got %#v", db.Token())
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			db.SetAccessToken("dummyoauthtoken")
+			db.SetToken(&oauth2.Token{AccessToken: "dummyoauthtoken"})
+			db.SetRefreshToken("dummyrefreshtoken")
+		}()
+		go func() {
+			defer wg.Done()
+			db.SaveToken(ioutil.Discard)
+			db.AccessToken()
+			db.Token()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRefreshToken(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Account
+	var index int
+
+	expected := Account{DisplayName: "John P. User", UID: 12345678}
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	db.SetRefreshToken("dummyrefreshtoken")
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{
+			index: &index,
+			pages: []FakeHTTP{
+				{
+					t:            t,
+					Method:       "GET",
+					Host:         "api.dropbox.com",
+					Path:         "/1/account/info",
+					Params:       map[string]string{"locale": "en"},
+					Headers:      map[string]string{"Authorization": "Bearer dummyoauthtoken"},
+					StatusCode:   http.StatusUnauthorized,
+					ResponseData: []byte(`{"error": "The given OAuth 2 access token is expired."}`),
+				},
+				{
+					t:            t,
+					Method:       "POST",
+					Host:         "api.dropbox.com",
+					Path:         "/1/oauth2/token",
+					ResponseData: []byte(`{"access_token": "newoauthtoken", "token_type": "bearer", "expires_in": 14400}`),
+				},
+				{
+					t:            t,
+					Method:       "GET",
+					Host:         "api.dropbox.com",
+					Path:         "/1/account/info",
+					Params:       map[string]string{"locale": "en"},
+					Headers:      map[string]string{"Authorization": "Bearer newoauthtoken"},
+					ResponseData: js,
+				},
+			},
+		},
+	}
+
+	if received, err = db.GetAccountInfo(); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+	if db.AccessToken() != "newoauthtoken" || db.Token().RefreshToken != "dummyrefreshtoken" {
+		t.Errorf("token was not refreshed: %#v", db.Token())
+	}
+}
+
+func TestGetFile(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var data []byte
+	var entry *Entry
+	var content []byte
+
+	content = []byte("file content")
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api-content.dropbox.com",
+			Path:         "/1/files/auto/testfile",
+			ResponseData: content,
+		},
+	}
+
+	if data, entry, err = db.GetFile("testfile", ""); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !bytes.Equal(data, content) {
+		t.Errorf("got %q expected %q", data, content)
+	} else if entry != nil {
+		t.Errorf("got %#v expected no metadata", entry)
+	}
+
+	db.MaxGetFileSize = 4
+	if _, _, err = db.GetFile("testfile", ""); err == nil {
+		t.Errorf("a file bigger than MaxGetFileSize must return an error")
+	}
+}
+
+func TestDownloadWithMetadata(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var entry *Entry
+	var content, js []byte
+
+	content = []byte("file content")
+	expected := fileEntry
+	js, err = json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:              t,
+			Method:         "GET",
+			Host:           "api-content.dropbox.com",
+			Path:           "/1/files/auto/testfile",
+			ResponseData:   content,
+			ResponseHeader: http.Header{"X-Dropbox-Metadata": {string(js)}},
+		},
+	}
+
+	if _, _, entry, err = db.DownloadWithMetadata("testfile", "", 0); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if entry == nil || !reflect.DeepEqual(expected, *entry) {
+		t.Errorf("got %#v expected %#v", entry, expected)
+	}
+}
+
+func TestThumbnailsSizes(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var body io.ReadCloser
+
+	db = newDropbox(t)
+	for _, size := range []string{"xs", "s", "m", "l", "xl", "w640h480", "w1024h768", "w2048h1536"} {
+		http.DefaultClient = &http.Client{
+			Transport: FakeHTTP{
+				t:      t,
+				Method: "GET",
+				Host:   "api-content.dropbox.com",
+				Path:   "/1/thumbnails/auto/image.jpg",
+				Params: map[string]string{
+					"format": "jpeg",
+					"size":   size,
+				},
+				ResponseData: []byte("thumbnail"),
+			},
+		}
+		if body, _, _, err = db.Thumbnails("image.jpg", "", size); err != nil {
+			t.Errorf("size %s: API error: %s", size, err)
+			continue
+		}
+		body.Close()
+	}
+
+	for _, size := range []string{"xxl", "w640", "w100h100", "W640H480"} {
+		if _, _, _, err = db.Thumbnails("image.jpg", "", size); err == nil {
+			t.Errorf("size %s should be rejected", size)
+		}
+	}
+}
+
+// stallingBody returns data then blocks until ctx is done, started is closed after data was read.
+type stallingBody struct {
+	data    *strings.Reader
+	ctx     context.Context
+	started chan struct{}
+	once    *sync.Once
+}
+
+func (b stallingBody) Read(p []byte) (int, error) {
+	if b.data.Len() != 0 {
+		return b.data.Read(p)
+	}
+	b.once.Do(func() { close(b.started) })
+	<-b.ctx.Done()
+	return 0, b.ctx.Err()
+}
+
+func (b stallingBody) Close() error {
+	return nil
+}
+
+// stallingHTTP replies with a stallingBody.
+type stallingHTTP struct {
+	started chan struct{}
+}
+
+func (s stallingHTTP) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := stallingBody{data: strings.NewReader("partial thumbnail"), ctx: req.Context(), started: s.started, once: &sync.Once{}}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: body}, nil
+}
+
+func TestThumbnailsToFileContext(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var tmpdir string
+
+	if tmpdir, err = ioutil.TempDir("", "dropbox"); err != nil {
+		t.Fatalf("could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dst := filepath.Join(tmpdir, "thumbnail.jpg")
+
+	db = newDropbox(t)
+	started := make(chan struct{})
+	http.DefaultClient = &http.Client{Transport: stallingHTTP{started: started}}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err = db.ThumbnailsToFileContext(ctx, "image.jpg", dst, "", ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v expected context.Canceled", err)
+	}
+	if _, err = os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("the partial file was not removed: %v", err)
+	}
+
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api-content.dropbox.com",
+			Path:         "/1/thumbnails/auto/image.jpg",
+			Params:       map[string]string{"format": "jpeg", "size": "s"},
+			ResponseData: []byte("thumbnail"),
+		},
+	}
+	if _, err = db.ThumbnailsToFileContext(context.Background(), "image.jpg", dst, "", ""); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if data, _ := ioutil.ReadFile(dst); string(data) != "thumbnail" {
+		t.Errorf("got %q expected thumbnail", data)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var entry *Entry
+	var content, js []byte
+	var tmpdir string
+
+	content = []byte("%PDF-1.4")
+	expected := fileEntry
+	if js, err = json.Marshal(expected); err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:              t,
+			Method:         "GET",
+			Host:           "api-content.dropbox.com",
+			Path:           "/1/previews/auto/report.doc",
+			Params:         map[string]string{"rev": "12345"},
+			ResponseData:   content,
+			ResponseHeader: http.Header{"X-Dropbox-Metadata": {string(js)}},
+		},
+	}
+
+	if tmpdir, err = ioutil.TempDir("", "dropbox"); err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dst := filepath.Join(tmpdir, "report.pdf")
+
+	if entry, err = db.PreviewToFile("report.doc", dst, "12345"); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if entry == nil || !reflect.DeepEqual(expected, *entry) {
+		t.Errorf("got %#v expected %#v", entry, expected)
+	} else if received, _ := ioutil.ReadFile(dst); !bytes.Equal(received, content) {
+		t.Errorf("got %q expected %q", received, content)
+	}
+
+	for _, status := range []int{http.StatusNotFound, http.StatusUnsupportedMediaType} {
+		http.DefaultClient = &http.Client{
+			Transport: FakeHTTP{
+				t:          t,
+				Method:     "GET",
+				Host:       "api-content.dropbox.com",
+				Path:       "/1/previews/auto/report.doc",
+				StatusCode: status,
+			},
+		}
+		_, _, _, err = db.Preview("report.doc", "")
+		if status == http.StatusNotFound && err != os.ErrNotExist {
+			t.Errorf("got %v expected os.ErrNotExist", err)
+		} else if status != http.StatusNotFound && (err == nil || err == os.ErrNotExist) {
+			t.Errorf("got %v expected an unsupported media error", err)
+		}
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var body io.ReadCloser
+	var e *Error
+
+	tab := []struct {
+		start, end int64
+		header     string
+	}{
+		{10, 19, "bytes=10-19"},
+		{10, -1, "bytes=10-"},
+		{0, 0, "bytes=0-0"},
+		{-65536, -1, "bytes=-65536"},
+	}
+
+	db = newDropbox(t)
+	for _, tc := range tab {
+		http.DefaultClient = &http.Client{
+			Transport: FakeHTTP{
+				t:            t,
+				Method:       "GET",
+				Host:         "api-content.dropbox.com",
+				Path:         "/1/files/auto/testfile",
+				Headers:      map[string]string{"Range": tc.header},
+				StatusCode:   http.StatusPartialContent,
+				ResponseData: []byte("partial"),
+			},
+		}
+		if body, _, err = db.DownloadRange("testfile", "", tc.start, tc.end); err != nil {
+			t.Errorf("%s: API error: %s", tc.header, err)
+			continue
+		}
+		body.Close()
+	}
+
+	if _, _, err = db.DownloadRange("testfile", "", 20, 10); err == nil {
+		t.Errorf("an invalid range should be rejected")
+	}
+
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:          t,
+			Method:     "GET",
+			Host:       "api-content.dropbox.com",
+			Path:       "/1/files/auto/testfile",
+			Headers:    map[string]string{"Range": "bytes=1000-"},
+			StatusCode: http.StatusRequestedRangeNotSatisfiable,
+		},
+	}
+	if _, _, err = db.DownloadRange("testfile", "", 1000, -1); !errors.As(err, &e) || e.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("got %v expected a range not satisfiable error", err)
+	}
+}
+
+func TestDisableCompression(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var body io.ReadCloser
+	var size int64
+
+	content := bytes.Repeat([]byte("file content "), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write(content)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write(content)
+		gz.Close()
+	}))
+	defer server.Close()
+
+	db = newDropbox(t)
+	db.APIContentURL = server.URL
+	db.HTTPClient = &http.Client{Transport: &http.Transport{}}
+	for _, disable := range []bool{false, true} {
+		db.DisableCompression = disable
+		expected := int64(-1)
+		if disable {
+			expected = int64(len(content))
+		}
+		if body, size, err = db.Download("testfile", "", 0); err != nil {
+			t.Errorf("API error: %s", err)
+			continue
+		}
+		received, _ := ioutil.ReadAll(body)
+		body.Close()
+		if !bytes.Equal(received, content) {
+			t.Errorf("DisableCompression %v: the data received does not match", disable)
+		}
+		if size != expected {
+			t.Errorf("DisableCompression %v: got size %d expected %d", disable, size, expected)
+		}
+	}
+}
+
+func TestDownloadIfChanged(t *testing.T) {
+	var err error
+	var body io.ReadCloser
+	var entry *Entry
+	var changed bool
+	var index int
+
+	js, err := json.Marshal(fileEntry)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+	metadata := FakeHTTP{
+		t:            t,
+		Method:       "GET",
+		Host:         "api.dropbox.com",
+		Path:         "/1/metadata/auto/testfile",
+		Params:       map[string]string{"list": "false", "include_deleted": "false", "file_limit": "10000", "locale": "en"},
+		ResponseData: js,
+	}
+	download := FakeHTTP{
+		t:            t,
+		Method:       "GET",
+		Host:         "api-content.dropbox.com",
+		Path:         "/1/files/auto/testfile",
+		Params:       map[string]string{"rev": fileEntry.Revision},
+		ResponseData: []byte("content"),
+	}
+
+	db := newDropbox(t)
+	http.DefaultClient = &http.Client{Transport: metadata}
+	if body, entry, changed, err = db.DownloadIfChanged("testfile", fileEntry.Revision); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if changed || body != nil {
+		t.Errorf("unchanged file should not be downloaded")
+	} else if !reflect.DeepEqual(fileEntry, *entry) {
+		t.Errorf("got %#v expected %#v", *entry, fileEntry)
+	}
+
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{metadata, download}},
+	}
+	if body, entry, changed, err = db.DownloadIfChanged("testfile", "1f33043551e"); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	defer body.Close()
+	if !changed {
+		t.Errorf("changed file should be downloaded")
+	}
+	if data, _ := ioutil.ReadAll(body); string(data) != "content" {
+		t.Errorf("got %q expected %q", data, "content")
+	}
+	if entry.Revision != fileEntry.Revision {
+		t.Errorf("got revision %s expected %s", entry.Revision, fileEntry.Revision)
+	}
+}
+
+func TestPutString(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *Entry
+
+	expected := fileEntry
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "PUT",
+			Host:   "api-content.dropbox.com",
+			Path:   "/1/files_put/auto/testfile",
+			Params: map[string]string{
+				"locale":    "en",
+				"overwrite": "true",
+			},
+			RequestData:  []byte("file content"),
+			ResponseData: js,
+		},
+	}
+	if received, err = db.PutString("file content", "testfile", true, ""); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+}
+
+func TestPing(t *testing.T) {
+	var db *Dropbox
+
+	db = newDropbox(t)
+	fake := FakeHTTP{
+		t:            t,
+		Method:       "GET",
+		Host:         "api.dropbox.com",
+		Path:         "/1/account/info",
+		Params:       map[string]string{"locale": "en"},
+		ResponseData: []byte(`{"uid": 12345678}`),
+	}
+	http.DefaultClient = &http.Client{
+		Transport: fake,
+	}
+	if err := db.Ping(); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+
+	// The reply is discarded, its fields are not unknown.
+	db.StrictJSON = true
+	if err := db.Ping(); err != nil {
+		t.Errorf("API error with StrictJSON: %s", err)
+	}
+
+	fake.StatusCode = http.StatusUnauthorized
+	fake.ResponseData = []byte(`{"error": "The given OAuth 2 access token doesn't exist or has expired."}`)
+	http.DefaultClient = &http.Client{
+		Transport: fake,
+	}
+	if err := db.Ping(); err != ErrNotAuth {
+		t.Errorf("got %v expected ErrNotAuth", err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	var db *Dropbox
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "POST",
+			Host:         "api.dropbox.com",
+			Path:         "/1/disable_access_token",
+			Params:       map[string]string{"locale": "en"},
+			ResponseData: []byte(`{"unexpected": true}`),
+		},
+	}
+	db.StrictJSON = true
+	if err := db.Revoke(); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+	if db.Token() != nil {
+		t.Errorf("token should be cleared, got %#v", db.Token())
+	}
+	if err := db.Revoke(); err != ErrNotAuth {
+		t.Errorf("got %v expected ErrNotAuth", err)
+	}
+}
+
+func TestLongPollDelta(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received *DeltaPoll
+
+	tab := []struct {
+		timeout  int
+		expected string
+	}{
+		{0, ""},
+		{10, "30"},
+		{60, "60"},
+		{1000, "480"},
+	}
+
+	expected := DeltaPoll{Changes: true, Backoff: 60}
+	js, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	for _, testCase := range tab {
+		fake := FakeHTTP{
+			t:            t,
+			Method:       "GET",
+			Host:         "api-notify.dropbox.com",
+			Path:         "/1/longpoll_delta",
+			Params:       map[string]string{"cursor": "some"},
+			Headers:      map[string]string{"Authorization": ""},
+			ResponseData: js,
+		}
+		if len(testCase.expected) != 0 {
+			fake.Params["timeout"] = testCase.expected
+		}
+		http.DefaultClient = &http.Client{
+			Transport: fake,
+		}
+		if received, err = db.LongPollDelta("some", testCase.timeout); err != nil {
+			t.Errorf("API error: %s", err)
+		} else if !reflect.DeepEqual(expected, *received) {
+			t.Errorf("got %#v expected %#v", *received, expected)
+		}
+	}
+
+	if _, err = db.LongPollDelta("some", -1); err == nil {
+		t.Errorf("a negative timeout must return an error")
+	}
+}
+
+func TestLongPollDeltaContext(t *testing.T) {
+	var err error
+	var index int
+
+	db := newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, block: true},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err = db.LongPollDeltaContext(ctx, "some", 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v expected context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled poll returned after %s", elapsed)
+	}
+}
+
+func TestListFolder(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var received []Entry
+	var hash string
+
+	dir := dirEntry
+	dir.Hash = "efdac89c4da886a9cece1927e6c22977"
+	dir.Contents = []Entry{fileEntry}
+	js, err := json.Marshal(dir)
+	if err != nil {
+		t.Fatalf("could not run test marshalling issue")
+	}
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "GET",
+			Host:   "api.dropbox.com",
+			Path:   "/1/metadata/auto/testdir",
+			Params: map[string]string{
+				"list":            "true",
+				"include_deleted": "false",
+				"file_limit":      "10000",
+				"locale":          "en",
+			},
+			ResponseData: js,
+		},
+	}
+	if received, hash, err = db.ListFolder("testdir", false); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(dir.Contents, received) {
+		t.Errorf("got %#v expected %#v", received, dir.Contents)
+	} else if hash != dir.Hash {
+		t.Errorf("got hash %s expected %s", hash, dir.Hash)
+	}
+}
+
+func TestMetadataNotModified(t *testing.T) {
+	var err error
+	var db *Dropbox
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "GET",
+			Host:   "api.dropbox.com",
+			Path:   "/1/metadata/auto/testdir",
+			Params: map[string]string{
+				"list":            "true",
+				"include_deleted": "false",
+				"file_limit":      "10000",
+				"hash":            "efdac89c4da886a9cece1927e6c22977",
+				"locale":          "en",
+			},
+			StatusCode: http.StatusNotModified,
+		},
+	}
+	if _, err = db.Metadata("testdir", true, false, "efdac89c4da886a9cece1927e6c22977", "", 0); err != ErrNotModified {
+		t.Errorf("got %v expected ErrNotModified", err)
+	}
+}
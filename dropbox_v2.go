@@ -0,0 +1,903 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Client is the set of the main methods of Dropbox, code depending on it can be tested with a fake implementation.
+// It is also implemented by DropboxV2 which uses the version 2 of the API for the methods it overrides.
+type Client interface {
+	GetAccountInfo() (*Account, error)
+	Metadata(src string, list bool, includeDeleted bool, hash, rev string, limit int) (*Entry, error)
+	Download(src, rev string, offset int64) (io.ReadCloser, int64, error)
+	download(src, rev, byteRange string) (io.ReadCloser, int64, *Entry, error)
+	DownloadToFile(src, dst, rev string) error
+	FilesPut(input io.ReadCloser, size int64, dst string, overwrite bool, parentRev string) (*Entry, error)
+	UploadFile(src, dst string, overwrite bool, parentRev string) (*Entry, error)
+	UploadByChunk(input io.ReadCloser, chunksize int, dst string, overwrite bool, parentRev string) (*Entry, error)
+	Delta(cursor, pathPrefix string) (*DeltaPage, error)
+	LongPollDelta(cursor string, timeout int) (*DeltaPoll, error)
+	Search(path, query string, fileLimit int, includeDeleted bool) ([]Entry, error)
+	Revisions(src string, revLimit int) ([]Entry, error)
+	Restore(src string, rev string) (*Entry, error)
+	Copy(src, dst string, isRef bool) (*Entry, error)
+	CreateFolder(path string) (*Entry, error)
+	Delete(path string) (*Entry, error)
+	Move(src, dst string) (*Entry, error)
+	Shares(path string, shortURL bool) (*Link, error)
+	Media(path string) (*Link, error)
+}
+
+var (
+	_ Client = (*Dropbox)(nil)
+	_ Client = (*DropboxV2)(nil)
+)
+
+// primitives are the methods on which the helpers of Dropbox are built, DropboxV2 implements them with
+// the version 2 of the API so that its embedded Dropbox does not fall back to the version 1.
+type primitives interface {
+	GetAccountInfo() (*Account, error)
+	Metadata(src string, list bool, includeDeleted bool, hash, rev string, limit int) (*Entry, error)
+	Download(src, rev string, offset int64) (io.ReadCloser, int64, error)
+	download(src, rev, byteRange string) (io.ReadCloser, int64, *Entry, error)
+	FilesPut(input io.ReadCloser, size int64, dst string, overwrite bool, parentRev string) (*Entry, error)
+	FilesPutFunc(bodyFn func() (io.ReadCloser, error), size int64, dst string, overwrite bool, parentRev string) (*Entry, error)
+	uploadChunked(input io.ReadCloser, dst string, overwrite bool, parentRev string) (*Entry, error)
+}
+
+var (
+	_ primitives = (*Dropbox)(nil)
+	_ primitives = (*DropboxV2)(nil)
+)
+
+// DropboxV2 is a client using the version 2 of the Dropbox API.
+// Methods not overridden by DropboxV2 are provided by the embedded Dropbox and still use the version 1 of the API.
+type DropboxV2 struct {
+	*Dropbox
+	APIV2URL        string // Normal API URL.
+	APIV2ContentURL string // URL for transferring files.
+}
+
+// NewDropboxV2 returns a new DropboxV2 configured with the default values, see NewDropbox.
+// The helpers of the embedded Dropbox built on Metadata, Download or FilesPut (DownloadToFile, DownloadRange, GetFile, Upload, Walk, FS...)
+// use the version 2 of the API through the methods of DropboxV2.
+func NewDropboxV2() *DropboxV2 {
+	db := &DropboxV2{
+		Dropbox:         NewDropbox(),
+		APIV2URL:        "https://api.dropboxapi.com/2",
+		APIV2ContentURL: "https://content.dropboxapi.com/2",
+	}
+	db.Dropbox.api = db
+	return db
+}
+
+// NewDropboxV2WithOptions returns a new DropboxV2 configured with opts, see NewDropboxWithOptions.
+func NewDropboxV2WithOptions(opts ...Option) (*DropboxV2, error) {
+	db := NewDropboxV2()
+	if err := db.apply(opts); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Format of reply when http error code is 409.
+type requestErrorV2 struct {
+	ErrorSummary string `json:"error_summary"` // Description of this error.
+}
+
+// Metadata of a file or folder returned by the version 2 of the API.
+type metadataV2 struct {
+	Tag            string `json:".tag"`
+	Name           string `json:"name"`
+	PathLower      string `json:"path_lower"`
+	PathDisplay    string `json:"path_display"`
+	ClientModified string `json:"client_modified"`
+	ServerModified string `json:"server_modified"`
+	Rev            string `json:"rev"`
+	Size           int64  `json:"size"`
+	ContentHash    string `json:"content_hash"`
+}
+
+// entry converts the metadata to an Entry.
+func (m *metadataV2) entry() Entry {
+	var e Entry
+
+	e = Entry{
+		Bytes:       m.Size,
+		ContentHash: m.ContentHash,
+		IsDeleted:   m.Tag == "deleted",
+		IsDir:       m.Tag == "folder",
+		Path:        m.PathDisplay,
+		Revision:    m.Rev,
+	}
+	if t, err := time.Parse(time.RFC3339, m.ClientModified); err == nil {
+		e.ClientMtime = DBTime(t)
+	}
+	if t, err := time.Parse(time.RFC3339, m.ServerModified); err == nil {
+		e.Modified = DBTime(t)
+	}
+	return e
+}
+
+func getResponseV2(r *http.Response) ([]byte, error) {
+	var e requestErrorV2
+	var b []byte
+	var err error
+
+	if b, err = ioutil.ReadAll(r.Body); err != nil {
+		return nil, err
+	}
+	if isSuccess(r.StatusCode) {
+		return b, nil
+	}
+	if r.StatusCode == http.StatusTooManyRequests {
+		return nil, newRateLimitError(r)
+	}
+	if err = json.Unmarshal(b, &e); err == nil && len(e.ErrorSummary) != 0 {
+		return nil, &APIError{StatusCode: r.StatusCode, Reason: e.ErrorSummary}
+	}
+	switch r.StatusCode {
+	case http.StatusUnauthorized:
+		return nil, ErrNotAuth
+	case http.StatusBadRequest:
+		return nil, &APIError{StatusCode: r.StatusCode, Reason: strings.TrimSpace(string(b))}
+	}
+	return nil, newErrorf(r.StatusCode, "unexpected HTTP status code %d", r.StatusCode)
+}
+
+// pathV2 returns the path in the format expected by the version 2 of the API, the root folder is the empty string.
+func pathV2(path string) string {
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return ""
+	}
+	return "/" + path
+}
+
+// doRequestV2 calls an RPC endpoint with the JSON encoded arg and decodes the reply in receiver.
+func (db *DropboxV2) doRequestV2(endpoint string, arg, receiver interface{}) error {
+	var body []byte
+	var request *http.Request
+	var response *http.Response
+	var err error
+
+	if body, err = json.Marshal(arg); err != nil {
+		return err
+	}
+	if request, err = http.NewRequest("POST", fmt.Sprintf("%s/%s", db.APIV2URL, endpoint), bytes.NewReader(body)); err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept-Language", db.Locale)
+	if response, err = db.do(request); err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if body, err = getResponseV2(response); err != nil {
+		return err
+	}
+	return db.decodeJSON(bytes.NewReader(body), receiver)
+}
+
+// setAPIArg sets the Dropbox-API-Arg header of request to the JSON encoding of v.
+// The characters outside of printable ASCII are escaped as \uXXXX since they are not allowed in HTTP headers.
+func setAPIArg(request *http.Request, v interface{}) error {
+	var js []byte
+	var err error
+
+	if js, err = json.Marshal(v); err != nil {
+		return err
+	}
+	b := &strings.Builder{}
+	for _, r := range string(js) {
+		switch {
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r > 0xffff:
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(b, "\\u%04x\\u%04x", r1, r2)
+		default:
+			fmt.Fprintf(b, "\\u%04x", r)
+		}
+	}
+	request.Header.Set("Dropbox-API-Arg", b.String())
+	return nil
+}
+
+// newContentRequestV2 returns a request to a content endpoint with the JSON encoded arg in the Dropbox-API-Arg header.
+func (db *DropboxV2) newContentRequestV2(endpoint string, arg interface{}) (*http.Request, error) {
+	var request *http.Request
+	var err error
+
+	if request, err = http.NewRequest("POST", fmt.Sprintf("%s/%s", db.APIV2ContentURL, endpoint), nil); err != nil {
+		return nil, err
+	}
+	if err = setAPIArg(request, arg); err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept-Language", db.Locale)
+	return request, nil
+}
+
+// GetAccountInfo gets account information for the user currently authenticated.
+func (db *DropboxV2) GetAccountInfo() (*Account, error) {
+	var rv Account
+	var account struct {
+		Name struct {
+			DisplayName string `json:"display_name"`
+		} `json:"name"`
+		Country      string `json:"country"`
+		ReferralLink string `json:"referral_link"`
+	}
+
+	if err := db.doRequestV2("users/get_current_account", nil, &account); err != nil {
+		return nil, err
+	}
+	usage, err := db.SpaceUsage()
+	if err != nil {
+		return nil, err
+	}
+	rv = Account{
+		ReferralLink: account.ReferralLink,
+		DisplayName:  account.Name.DisplayName,
+		Country:      account.Country,
+	}
+	rv.QuotaInfo.Quota = usage.Allocation.Allocated
+	rv.QuotaInfo.Normal = usage.Used
+	return &rv, nil
+}
+
+// SpaceUsage returns the space usage of the account including the allocation of its team.
+func (db *DropboxV2) SpaceUsage() (*SpaceUsage, error) {
+	var usage struct {
+		Used       int64 `json:"used"`
+		Allocation struct {
+			Tag                          string `json:".tag"`
+			Allocated                    int64  `json:"allocated"`
+			Used                         int64  `json:"used"`
+			UserWithinTeamSpaceAllocated int64  `json:"user_within_team_space_allocated"`
+			UserWithinTeamSpaceLimitType struct {
+				Tag string `json:".tag"`
+			} `json:"user_within_team_space_limit_type"`
+		} `json:"allocation"`
+	}
+
+	if err := db.doRequestV2("users/get_space_usage", nil, &usage); err != nil {
+		return nil, err
+	}
+	return &SpaceUsage{
+		Used: usage.Used,
+		Allocation: SpaceAllocation{
+			Type:          usage.Allocation.Tag,
+			Allocated:     usage.Allocation.Allocated,
+			TeamUsed:      usage.Allocation.Used,
+			UserAllocated: usage.Allocation.UserWithinTeamSpaceAllocated,
+			UserLimitType: usage.Allocation.UserWithinTeamSpaceLimitType.Tag,
+		},
+	}, nil
+}
+
+// Metadata gets the metadata for a file or a directory.
+// If list is true and src is a directory, immediate child will be sent in the Contents field.
+// If include_deleted is true, entries deleted will be sent.
+// hash is not supported by the version 2 of the API and is ignored.
+// rev is the specific revision to get the metadata from.
+// limit is the maximum number of entries requested.
+func (db *DropboxV2) Metadata(src string, list bool, includeDeleted bool, hash, rev string, limit int) (*Entry, error) {
+	var rv Entry
+	var md metadataV2
+	var err error
+
+	if limit <= 0 {
+		limit = MetadataLimitDefault
+	} else if limit > MetadataLimitMax {
+		limit = MetadataLimitMax
+	}
+
+	src = pathV2(src)
+	if len(src) == 0 {
+		rv = Entry{Path: "/", IsDir: true}
+	} else {
+		arg := map[string]interface{}{"path": src, "include_deleted": includeDeleted}
+		if len(rev) != 0 {
+			arg["path"] = "rev:" + rev
+		}
+		if err = db.doRequestV2("files/get_metadata", arg, &md); err != nil {
+			return nil, err
+		}
+		rv = md.entry()
+	}
+	if !list || !rv.IsDir {
+		return &rv, nil
+	}
+	if rv.Contents, err = db.listFolderV2(src, includeDeleted, limit); err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+// listFolderV2 returns at most limit immediate children of the folder located at path.
+func (db *DropboxV2) listFolderV2(path string, includeDeleted bool, limit int) ([]Entry, error) {
+	var rv []Entry
+	var page struct {
+		Entries []metadataV2 `json:"entries"`
+		Cursor  string       `json:"cursor"`
+		HasMore bool         `json:"has_more"`
+	}
+	var err error
+
+	err = db.doRequestV2("files/list_folder", map[string]interface{}{
+		"path":            path,
+		"include_deleted": includeDeleted,
+		"limit":           limit,
+	}, &page)
+	for err == nil {
+		for i := range page.Entries {
+			if len(rv) == limit {
+				return rv, nil
+			}
+			rv = append(rv, page.Entries[i].entry())
+		}
+		if !page.HasMore {
+			return rv, nil
+		}
+		cursor := page.Cursor
+		page.Entries = nil
+		err = db.doRequestV2("files/list_folder/continue", map[string]string{"cursor": cursor}, &page)
+	}
+	return nil, err
+}
+
+// SearchDetailed searches the entries matching query in the given path with files/search_v2,
+// the match type and the highlighted parts of the names are set in the matches.
+// A fileLimit lower or equal to 0 uses SearchLimitDefault, greater values are capped to SearchLimitMax.
+// The live and deleted entries are requested separately, each up to fileLimit.
+func (db *DropboxV2) SearchDetailed(path, query string, fileLimit int, includeDeleted bool) (*SearchResult, error) {
+	var matches []SearchMatch
+	var truncated bool
+	var page struct {
+		Matches []struct {
+			MatchType struct {
+				Tag string `json:".tag"`
+			} `json:"match_type"`
+			Metadata struct {
+				Metadata metadataV2 `json:"metadata"`
+			} `json:"metadata"`
+			HighlightSpans []HighlightSpan `json:"highlight_spans"`
+		} `json:"matches"`
+		HasMore bool `json:"has_more"`
+	}
+
+	if fileLimit <= 0 {
+		fileLimit = SearchLimitDefault
+	} else if fileLimit > SearchLimitMax {
+		fileLimit = SearchLimitMax
+	}
+	statuses := []string{"active"}
+	if includeDeleted {
+		statuses = append(statuses, "deleted")
+	}
+	for _, status := range statuses {
+		page.Matches = nil
+		if err := db.doRequestV2("files/search_v2", map[string]interface{}{
+			"query": query,
+			"options": map[string]interface{}{
+				"path":        pathV2(path),
+				"max_results": fileLimit,
+				"file_status": status,
+			},
+			"match_field_options": map[string]bool{"include_highlights": true},
+		}, &page); err != nil {
+			return nil, err
+		}
+		for _, m := range page.Matches {
+			matches = append(matches, SearchMatch{
+				Entry:      m.Metadata.Metadata.entry(),
+				MatchType:  m.MatchType.Tag,
+				Highlights: m.HighlightSpans,
+			})
+		}
+		truncated = truncated || page.HasMore
+	}
+	return newSearchResult(matches, truncated), nil
+}
+
+// Shared link returned by the version 2 of the API.
+type sharedLinkV2 struct {
+	URL             string `json:"url"`
+	Expires         string `json:"expires"`
+	LinkPermissions struct {
+		ResolvedVisibility struct {
+			Tag string `json:".tag"`
+		} `json:"resolved_visibility"`
+	} `json:"link_permissions"`
+}
+
+// link converts the shared link to a Link.
+func (sl *sharedLinkV2) link() Link {
+	var l Link
+
+	l = Link{URL: sl.URL, Visibility: sl.LinkPermissions.ResolvedVisibility.Tag}
+	if t, err := time.Parse(time.RFC3339, sl.Expires); err == nil {
+		l.Expires = DBTime(t)
+	}
+	return l
+}
+
+// ListSharedLinks returns the shared links which already exist for the file or folder located at path.
+// The version 1 of the API has no equivalent so this method is only available with DropboxV2.
+func (db *DropboxV2) ListSharedLinks(path string) ([]Link, error) {
+	var rv []Link
+	var page struct {
+		Links   []sharedLinkV2 `json:"links"`
+		Cursor  string         `json:"cursor"`
+		HasMore bool           `json:"has_more"`
+	}
+	var err error
+
+	arg := map[string]interface{}{"path": pathV2(path), "direct_only": true}
+	err = db.doRequestV2("sharing/list_shared_links", arg, &page)
+	for err == nil {
+		for i := range page.Links {
+			rv = append(rv, page.Links[i].link())
+		}
+		if !page.HasMore {
+			return rv, nil
+		}
+		arg["cursor"] = page.Cursor
+		page.Links = nil
+		err = db.doRequestV2("sharing/list_shared_links", arg, &page)
+	}
+	return nil, err
+}
+
+// SharesWithSettings creates a shared link with the given settings.
+// ShortURL is not supported by the version 2 of the API and is ignored.
+func (db *DropboxV2) SharesWithSettings(path string, settings ShareSettings) (*Link, error) {
+	var sl sharedLinkV2
+
+	arg := map[string]interface{}{}
+	if len(settings.Visibility) != 0 {
+		arg["requested_visibility"] = settings.Visibility
+	}
+	if len(settings.Password) != 0 {
+		arg["link_password"] = settings.Password
+	}
+	if !settings.Expires.IsZero() {
+		arg["expires"] = settings.Expires.UTC().Format(time.RFC3339)
+	}
+	if err := db.doRequestV2("sharing/create_shared_link_with_settings", map[string]interface{}{
+		"path":     pathV2(path),
+		"settings": arg,
+	}, &sl); err != nil {
+		return nil, err
+	}
+	rv := sl.link()
+	return &rv, nil
+}
+
+// TemporaryLinkLifetime is the time during which a link returned by TemporaryLink is valid.
+const TemporaryLinkLifetime = 4 * time.Hour
+
+// TemporaryLink returns a direct link to the content of the file located at path and its metadata.
+// The reply has no expiration date, it is set to TemporaryLinkLifetime from now.
+// This replaces Media whose endpoint is deprecated.
+func (db *DropboxV2) TemporaryLink(path string) (*Link, *Entry, error) {
+	var rv struct {
+		Metadata metadataV2 `json:"metadata"`
+		Link     string     `json:"link"`
+	}
+
+	expires := time.Now().Add(TemporaryLinkLifetime).Truncate(time.Second)
+	if err := db.doRequestV2("files/get_temporary_link", map[string]string{"path": pathV2(path)}, &rv); err != nil {
+		return nil, nil, err
+	}
+	entry := rv.Metadata.entry()
+	return &Link{URL: rv.Link, Expires: DBTime(expires)}, &entry, nil
+}
+
+// TemporaryLink is like DropboxV2.TemporaryLink, it uses the version 2 of the API with its default URLs
+// unless db is embedded in a DropboxV2.
+func (db *Dropbox) TemporaryLink(path string) (*Link, *Entry, error) {
+	return db.v2().TemporaryLink(path)
+}
+
+// v2 returns the DropboxV2 embedding db if any, a DropboxV2 using db with the default URLs otherwise.
+func (db *Dropbox) v2() *DropboxV2 {
+	if rv, ok := db.api.(*DropboxV2); ok {
+		return rv
+	}
+	rv := NewDropboxV2()
+	rv.Dropbox = db
+	return rv
+}
+
+// Download requests the file located at src, the specific revision may be given.
+// offset is used in case the download was interrupted.
+// A io.ReadCloser and the file size is returned.
+func (db *DropboxV2) Download(src, rev string, offset int64) (io.ReadCloser, int64, error) {
+	var byteRange string
+
+	if offset != 0 {
+		byteRange = fmt.Sprintf("bytes=%d-", offset)
+	}
+	body, size, _, err := db.download(src, rev, byteRange)
+	return body, size, err
+}
+
+// download requests the file located at src, byteRange is the value of the Range header if not empty.
+// The metadata of the file is read from the Dropbox-API-Result header, it is nil if absent.
+func (db *DropboxV2) download(src, rev, byteRange string) (io.ReadCloser, int64, *Entry, error) {
+	var request *http.Request
+	var response *http.Response
+	var md metadataV2
+	var err error
+
+	path := pathV2(src)
+	if len(rev) != 0 {
+		path = "rev:" + rev
+	}
+	if request, err = db.newContentRequestV2("files/download", map[string]string{"path": path}); err != nil {
+		return nil, 0, nil, err
+	}
+	if len(byteRange) != 0 {
+		request.Header.Set("Range", byteRange)
+	}
+	if db.DisableCompression {
+		// The transport only asks for and decodes gzip when Accept-Encoding is not set.
+		request.Header.Set("Accept-Encoding", "identity")
+	}
+	if response, err = db.do(request); err != nil {
+		return nil, 0, nil, err
+	}
+	if isSuccess(response.StatusCode) {
+		if err = json.Unmarshal([]byte(response.Header.Get("Dropbox-API-Result")), &md); err != nil {
+			return response.Body, response.ContentLength, nil, nil
+		}
+		entry := md.entry()
+		return response.Body, response.ContentLength, &entry, nil
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return nil, 0, nil, newErrorf(response.StatusCode, "range %s is not satisfiable for the file located at '%s'", strings.TrimPrefix(byteRange, "bytes="), pathV2(src))
+	}
+	if _, err = getResponseV2(response); errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil, os.ErrNotExist
+	}
+	return nil, 0, nil, err
+}
+
+// DownloadSharedLink requests the content of the file shared with the link, password is required for protected links.
+// A io.ReadCloser and the file size are returned, os.ErrNotExist if the link does not exist.
+func (db *DropboxV2) DownloadSharedLink(link, password string) (io.ReadCloser, int64, error) {
+	var request *http.Request
+	var response *http.Response
+	var ae *APIError
+	var err error
+
+	arg := map[string]string{"url": link}
+	if len(password) != 0 {
+		arg["link_password"] = password
+	}
+	if request, err = db.newContentRequestV2("sharing/get_shared_link_file", arg); err != nil {
+		return nil, 0, err
+	}
+	if response, err = db.do(request); err != nil {
+		return nil, 0, err
+	}
+	if isSuccess(response.StatusCode) {
+		return response.Body, response.ContentLength, nil
+	}
+	defer response.Body.Close()
+	if _, err = getResponseV2(response); errors.As(err, &ae) && strings.HasPrefix(ae.Reason, "shared_link_not_found") {
+		return nil, 0, os.ErrNotExist
+	}
+	return nil, 0, err
+}
+
+// ErrZipTooLarge is matched by the errors returned by DownloadZip when the folder has too many files
+// or is too large to be downloaded as a zip.
+var ErrZipTooLarge = errors.New("folder too large to be downloaded as a zip")
+
+// DownloadZip requests the content of the folder as a zip archive.
+// A io.ReadCloser and the size of the archive (-1 if unknown) are returned, os.ErrNotExist if the folder does not exist.
+func (db *DropboxV2) DownloadZip(folder string) (io.ReadCloser, int64, error) {
+	var request *http.Request
+	var response *http.Response
+	var ae *APIError
+	var err error
+
+	if request, err = db.newContentRequestV2("files/download_zip", map[string]string{"path": pathV2(folder)}); err != nil {
+		return nil, 0, err
+	}
+	if response, err = db.do(request); err != nil {
+		return nil, 0, err
+	}
+	if isSuccess(response.StatusCode) {
+		return response.Body, response.ContentLength, nil
+	}
+	defer response.Body.Close()
+	_, err = getResponseV2(response)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, 0, os.ErrNotExist
+	case errors.As(err, &ae) && (strings.HasPrefix(ae.Reason, "too_large") || strings.HasPrefix(ae.Reason, "too_many_files")):
+		return nil, 0, fmt.Errorf("%w: %v", ErrZipTooLarge, err)
+	}
+	return nil, 0, err
+}
+
+// DownloadZipToFile downloads the content of the folder as a zip archive in the local file dst.
+func (db *DropboxV2) DownloadZipToFile(folder, dst string) error {
+	var input io.ReadCloser
+	var fd *os.File
+	var err error
+
+	if fd, err = os.Create(dst); err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if input, _, err = db.DownloadZip(folder); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	defer input.Close()
+	if _, err = io.Copy(fd, input); err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// FilesPut uploads size bytes from the input reader to the dst path on Dropbox.
+// The upload is only retried on transient errors when input implements io.Seeker.
+func (db *DropboxV2) FilesPut(input io.ReadCloser, size int64, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	return db.FilesPutClientMtime(input, size, dst, overwrite, parentRev, time.Time{})
+}
+
+// FilesPutClientMtime is like FilesPut but sets the modification time of the file to clientMtime (see Entry.ClientMtime),
+// it is rounded to the second. The time of the upload is used if clientMtime is zero.
+func (db *DropboxV2) FilesPutClientMtime(input io.ReadCloser, size int64, dst string, overwrite bool, parentRev string, clientMtime time.Time) (*Entry, error) {
+	defer input.Close()
+	return db.filesPutV2(size, dst, overwrite, parentRev, clientMtime, func(request *http.Request, h hash.Hash) error {
+		setRequestBody(request, input, size, nil, h)
+		return nil
+	})
+}
+
+// FilesPutFunc is like FilesPut but calls bodyFn to open the data to send for each attempt,
+// the upload can then be retried on transient errors whatever the source. Each reader returned by bodyFn is closed.
+func (db *DropboxV2) FilesPutFunc(bodyFn func() (io.ReadCloser, error), size int64, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	return db.filesPutV2(size, dst, overwrite, parentRev, time.Time{}, func(request *http.Request, h hash.Hash) error {
+		return setRequestBodyFunc(request, bodyFn, size, nil, h)
+	})
+}
+
+// uploadChunked uploads the data read from input with an upload session, see UploadLarge.
+func (db *DropboxV2) uploadChunked(input io.ReadCloser, dst string, overwrite bool, parentRev string) (*Entry, error) {
+	return db.UploadLarge(input, 0, dst, overwrite, parentRev, time.Time{})
+}
+
+// filesPutV2 uploads size bytes to the dst path on Dropbox with files/upload, the body of the request is set by setBody.
+func (db *DropboxV2) filesPutV2(size int64, dst string, overwrite bool, parentRev string, clientMtime time.Time, setBody func(*http.Request, hash.Hash) error) (*Entry, error) {
+	var request *http.Request
+	var response *http.Response
+	var md metadataV2
+	var h hash.Hash
+	var body []byte
+	var err error
+	var rv Entry
+
+	if max := db.maxSinglePutSize(); size > max {
+		return nil, fmt.Errorf("could not upload files bigger than %d bytes using this method, use UploadLarge instead", max)
+	}
+	if err = ValidatePath(dst); err != nil {
+		return nil, err
+	}
+
+	if request, err = db.newContentRequestV2("files/upload", commitInfoV2(dst, overwrite, parentRev, clientMtime)); err != nil {
+		return nil, err
+	}
+	h = db.uploadHasher()
+	if err = setBody(request, h); err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	if response, err = db.do(request); err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if body, err = getResponseV2(response); err != nil {
+		return db.checkUploadConflict(dst, nil, err)
+	}
+	if err = json.Unmarshal(body, &md); err != nil {
+		return nil, err
+	}
+	rv = md.entry()
+	if err = db.verifyContentHash(&rv, h); err != nil {
+		return nil, err
+	}
+	return db.checkUploadConflict(dst, &rv, nil)
+}
+
+// commitInfoV2 returns the arguments describing where and how an uploaded file is written.
+// The modification time of the file is set to clientMtime, rounded to the second, unless it is zero.
+func commitInfoV2(dst string, overwrite bool, parentRev string, clientMtime time.Time) map[string]interface{} {
+	var mode interface{}
+
+	switch {
+	case len(parentRev) != 0:
+		mode = map[string]string{".tag": "update", "update": parentRev}
+	case overwrite:
+		mode = "overwrite"
+	default:
+		mode = "add"
+	}
+	rv := map[string]interface{}{
+		"path":       pathV2(dst),
+		"mode":       mode,
+		"autorename": !overwrite,
+	}
+	if !clientMtime.IsZero() {
+		rv["client_modified"] = clientMtime.UTC().Truncate(time.Second).Format(time.RFC3339)
+	}
+	return rv
+}
+
+// sendContentV2 sends size bytes from input to a content endpoint and decodes the reply in receiver if not nil.
+// The request is only retried on transient errors when input implements io.Seeker.
+func (db *DropboxV2) sendContentV2(endpoint string, arg interface{}, input io.Reader, size int64, receiver interface{}) error {
+	var request *http.Request
+	var response *http.Response
+	var body []byte
+	var err error
+
+	if request, err = db.newContentRequestV2(endpoint, arg); err != nil {
+		return err
+	}
+	setRequestBody(request, input, size, nil, nil)
+	request.Header.Set("Content-Type", "application/octet-stream")
+	if response, err = db.do(request); err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if body, err = getResponseV2(response); err != nil || receiver == nil {
+		return err
+	}
+	return json.Unmarshal(body, receiver)
+}
+
+// UploadSession is an upload session of the version 2 of the API, Offset is the number of bytes received by the server.
+type UploadSession struct {
+	SessionID string `json:"session_id"`
+	Offset    int64  `json:"offset"`
+}
+
+// UploadSessionStart starts an upload session with the first size bytes read from input.
+// If closeSession is true no more data can be appended, the session can only be finished.
+func (db *DropboxV2) UploadSessionStart(input io.Reader, size int64, closeSession bool) (*UploadSession, error) {
+	var rv UploadSession
+
+	if err := db.sendContentV2("files/upload_session/start", map[string]bool{"close": closeSession}, input, size, &rv); err != nil {
+		return nil, err
+	}
+	rv.Offset = size
+	return &rv, nil
+}
+
+// UploadSessionAppend sends the next size bytes read from input in session and advances its offset.
+// If closeSession is true no more data can be appended, the session can only be finished.
+func (db *DropboxV2) UploadSessionAppend(session *UploadSession, input io.Reader, size int64, closeSession bool) error {
+	if err := db.sendContentV2("files/upload_session/append_v2", map[string]interface{}{
+		"cursor": session,
+		"close":  closeSession,
+	}, input, size, nil); err != nil {
+		return err
+	}
+	session.Offset += size
+	return nil
+}
+
+// UploadSessionFinish sends the last size bytes read from input (size may be 0) and commits the data of session to dst.
+// The modification time of the file is set to clientMtime if it is not zero, see FilesPutClientMtime.
+func (db *DropboxV2) UploadSessionFinish(session *UploadSession, input io.Reader, size int64, dst string, overwrite bool, parentRev string, clientMtime time.Time) (*Entry, error) {
+	var md metadataV2
+
+	if err := ValidatePath(dst); err != nil {
+		return nil, err
+	}
+	if err := db.sendContentV2("files/upload_session/finish", map[string]interface{}{
+		"cursor": session,
+		"commit": commitInfoV2(dst, overwrite, parentRev, clientMtime),
+	}, input, size, &md); err != nil {
+		return nil, err
+	}
+	session.Offset += size
+	rv := md.entry()
+	return &rv, nil
+}
+
+// UploadLarge uploads the data read from input to the dst path on Dropbox with an upload session,
+// this is the way to upload files bigger than MaxPutFileSize.
+// The data is sent by chunks of chunksize kept in memory, see UploadByChunk for the default and the maximum size.
+// Each chunk is retried on transient errors. The modification time of the file is set to clientMtime if it is not zero.
+func (db *DropboxV2) UploadLarge(input io.ReadCloser, chunksize int, dst string, overwrite bool, parentRev string, clientMtime time.Time) (*Entry, error) {
+	var session *UploadSession
+	var entry *Entry
+	var h hash.Hash
+	var r io.Reader = input
+	var n int
+	var err error
+
+	defer input.Close()
+	if err = ValidatePath(dst); err != nil {
+		return nil, err
+	}
+	if h = db.uploadHasher(); h != nil {
+		r = io.TeeReader(input, h)
+	}
+	buf := make([]byte, db.chunkSize(chunksize))
+	for {
+		if n, err = io.ReadFull(r, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if session == nil {
+			session, err = db.UploadSessionStart(bytes.NewReader(buf), int64(n), false)
+		} else {
+			err = db.UploadSessionAppend(session, bytes.NewReader(buf), int64(n), false)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if session == nil {
+		if session, err = db.UploadSessionStart(bytes.NewReader(nil), 0, false); err != nil {
+			return nil, err
+		}
+	}
+	if entry, err = db.UploadSessionFinish(session, bytes.NewReader(buf[:n]), int64(n), dst, overwrite, parentRev, clientMtime); err != nil {
+		return db.checkUploadConflict(dst, nil, err)
+	}
+	if err = db.verifyContentHash(entry, h); err != nil {
+		return nil, err
+	}
+	return db.checkUploadConflict(dst, entry, nil)
+}
@@ -0,0 +1,836 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newDropboxV2(t *testing.T) *DropboxV2 {
+	db := NewDropboxV2()
+	db.SetAppInfo("dummyappkey", "dummyappsecret")
+	db.SetAccessToken("dummyoauthtoken")
+	return db
+}
+
+func TestAccountInfoV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var received *Account
+	var index int
+
+	db = newDropboxV2(t)
+	expected := Account{DisplayName: "Franz Ferdinand", Country: "US", ReferralLink: "https://db.tt/ZITNuhtI"}
+	expected.QuotaInfo.Quota = 10000000000
+	expected.QuotaInfo.Normal = 314159265
+
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{
+			index: &index,
+			pages: []FakeHTTP{
+				{
+					t:            t,
+					Method:       "POST",
+					Host:         "api.dropboxapi.com",
+					Path:         "/2/users/get_current_account",
+					RequestData:  []byte("null"),
+					ResponseData: []byte(`{"account_id": "dbid:AAH4f99T0taONIb-OurWxbNQ6ywGRopQngc", "name": {"display_name": "Franz Ferdinand"}, "country": "US", "referral_link": "https://db.tt/ZITNuhtI"}`),
+				},
+				{
+					t:            t,
+					Method:       "POST",
+					Host:         "api.dropboxapi.com",
+					Path:         "/2/users/get_space_usage",
+					RequestData:  []byte("null"),
+					ResponseData: []byte(`{"used": 314159265, "allocation": {".tag": "individual", "allocated": 10000000000}}`),
+				},
+			},
+		},
+	}
+
+	if received, err = db.GetAccountInfo(); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+}
+
+func TestMetadataV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var received *Entry
+	var index int
+
+	db = newDropboxV2(t)
+	expected := Entry{Path: "/testdir", IsDir: true, Contents: []Entry{
+		{
+			Path:        "/testdir/testfile",
+			Bytes:       7212,
+			Revision:    "a1c10ce0dd78",
+			Modified:    DBTime(time.Date(2015, time.May, 12, 15, 50, 38, 0, time.UTC)),
+			ClientMtime: DBTime(time.Date(2015, time.May, 12, 15, 50, 38, 0, time.UTC)),
+		},
+	}}
+
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{
+			index: &index,
+			pages: []FakeHTTP{
+				{
+					t:            t,
+					Method:       "POST",
+					Host:         "api.dropboxapi.com",
+					Path:         "/2/files/get_metadata",
+					Headers:      map[string]string{"Content-Type": "application/json"},
+					RequestData:  []byte(`{"include_deleted":false,"path":"/testdir"}`),
+					ResponseData: []byte(`{".tag": "folder", "name": "testdir", "path_display": "/testdir"}`),
+				},
+				{
+					t:            t,
+					Method:       "POST",
+					Host:         "api.dropboxapi.com",
+					Path:         "/2/files/list_folder",
+					RequestData:  []byte(`{"include_deleted":false,"limit":10,"path":"/testdir"}`),
+					ResponseData: []byte(`{"entries": [], "cursor": "ZtkX9_EHj3x7PMkVuFIhwKYXEpwpLwyxp9vMKomUhllil9q7eWiAu", "has_more": true}`),
+				},
+				{
+					t:            t,
+					Method:       "POST",
+					Host:         "api.dropboxapi.com",
+					Path:         "/2/files/list_folder/continue",
+					RequestData:  []byte(`{"cursor":"ZtkX9_EHj3x7PMkVuFIhwKYXEpwpLwyxp9vMKomUhllil9q7eWiAu"}`),
+					ResponseData: []byte(`{"entries": [{".tag": "file", "name": "testfile", "path_display": "/testdir/testfile", "client_modified": "2015-05-12T15:50:38Z", "server_modified": "2015-05-12T15:50:38Z", "rev": "a1c10ce0dd78", "size": 7212}], "cursor": "", "has_more": false}`),
+				},
+			},
+		},
+	}
+
+	if received, err = db.Metadata("testdir", true, false, "", "", 10); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+}
+
+func TestDownloadZipV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var tmpdir string
+	var archive bytes.Buffer
+	var zr *zip.ReadCloser
+
+	zw := zip.NewWriter(&archive)
+	if w, err := zw.Create("folder/testfile"); err == nil {
+		w.Write([]byte("file content"))
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatalf("could not create the archive: %s", err)
+	}
+	if tmpdir, err = ioutil.TempDir("", "dropbox"); err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dst := filepath.Join(tmpdir, "folder.zip")
+
+	db = newDropboxV2(t)
+	fake := FakeHTTP{
+		t:            t,
+		Method:       "POST",
+		Host:         "content.dropboxapi.com",
+		Path:         "/2/files/download_zip",
+		Headers:      map[string]string{"Dropbox-API-Arg": `{"path":"/folder"}`},
+		ResponseData: archive.Bytes(),
+	}
+	http.DefaultClient = &http.Client{Transport: fake}
+	if err = db.DownloadZipToFile("folder", dst); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if zr, err = zip.OpenReader(dst); err != nil {
+		t.Fatalf("invalid archive: %s", err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 1 || zr.File[0].Name != "folder/testfile" {
+		t.Errorf("got %d files expected folder/testfile", len(zr.File))
+	}
+
+	fake.StatusCode = http.StatusConflict
+	fake.ResponseData = []byte(`{"error_summary": "path/not_found/..", "error": {".tag": "path", "path": {".tag": "not_found"}}}`)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if _, _, err = db.DownloadZip("folder"); err != os.ErrNotExist {
+		t.Errorf("got %v expected os.ErrNotExist", err)
+	}
+
+	fake.ResponseData = []byte(`{"error_summary": "too_large/..", "error": {".tag": "too_large"}}`)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if err = db.DownloadZipToFile("folder", dst); !errors.Is(err, ErrZipTooLarge) {
+		t.Errorf("got %v expected ErrZipTooLarge", err)
+	}
+	if _, err = os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("the file must be removed on error: %v", err)
+	}
+}
+
+func TestFilesV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var content []byte
+
+	db = newDropboxV2(t)
+	fake := FakeHTTP{
+		t:            t,
+		Method:       "POST",
+		Host:         "content.dropboxapi.com",
+		Path:         "/2/files/download",
+		Headers:      map[string]string{"Dropbox-API-Arg": `{"path":"/testfile"}`},
+		ResponseData: []byte("file content"),
+	}
+	http.DefaultClient = &http.Client{
+		Transport: fake,
+	}
+
+	if _, _, err = db.Download("testfile", "", 0); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+
+	fake.StatusCode = http.StatusConflict
+	fake.ResponseData = []byte(`{"error_summary": "path/not_found/..", "error": {".tag": "path", "path": {".tag": "not_found"}}}`)
+	http.DefaultClient = &http.Client{
+		Transport: fake,
+	}
+	if _, _, err = db.Download("testfile", "", 0); err != os.ErrNotExist {
+		t.Errorf("got %v expected os.ErrNotExist", err)
+	}
+
+	content = []byte("file content")
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:      t,
+			Method: "POST",
+			Host:   "content.dropboxapi.com",
+			Path:   "/2/files/upload",
+			Headers: map[string]string{
+				"Dropbox-API-Arg": `{"autorename":false,"mode":{".tag":"update","update":"a1c10ce0dd78"},"path":"/testfile"}`,
+				"Content-Type":    "application/octet-stream",
+			},
+			RequestData:  content,
+			ResponseData: []byte(`{"name": "testfile", "path_display": "/testfile", "rev": "a1c10ce0dd79", "size": 12}`),
+		},
+	}
+	if _, err = db.FilesPut(ioutil.NopCloser(bytes.NewReader(content)), int64(len(content)), "testfile", true, "a1c10ce0dd78"); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+}
+
+func TestVerifyUploadsV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var content []byte
+
+	content = []byte("file content")
+	db = newDropboxV2(t)
+	db.VerifyUploads = true
+	fake := FakeHTTP{
+		t:            t,
+		Method:       "POST",
+		Host:         "content.dropboxapi.com",
+		Path:         "/2/files/upload",
+		RequestData:  content,
+		ResponseData: []byte(`{"name": "testfile", "path_display": "/testfile", "size": 12, "content_hash": "7010da024b3bbb581ca2e65653e45dee902d73851987b9cfb79c6583bfeec6f1"}`),
+	}
+	http.DefaultClient = &http.Client{
+		Transport: fake,
+	}
+	if _, err = db.FilesPut(ioutil.NopCloser(bytes.NewReader(content)), int64(len(content)), "testfile", false, ""); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+
+	fake.ResponseData = []byte(`{"name": "testfile", "path_display": "/testfile", "size": 12, "content_hash": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}`)
+	http.DefaultClient = &http.Client{
+		Transport: fake,
+	}
+	if _, err = db.FilesPut(ioutil.NopCloser(bytes.NewReader(content)), int64(len(content)), "testfile", false, ""); !errors.Is(err, ErrContentHashMismatch) {
+		t.Errorf("got %v expected ErrContentHashMismatch", err)
+	}
+}
+
+func TestFilesPutClientMtimeV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var entry *Entry
+	var index int
+
+	content := []byte("file content")
+	mtime := time.Date(2015, time.May, 12, 15, 50, 38, 500, time.FixedZone("CEST", 2*3600))
+	expected := DBTime(time.Date(2015, time.May, 12, 13, 50, 38, 0, time.UTC))
+	md := []byte(`{".tag": "file", "name": "testfile", "path_display": "/testfile", "size": 12, "client_modified": "2015-05-12T13:50:38Z"}`)
+
+	db = newDropboxV2(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			{
+				t:      t,
+				Method: "POST",
+				Host:   "content.dropboxapi.com",
+				Path:   "/2/files/upload",
+				Headers: map[string]string{
+					"Dropbox-API-Arg": `{"autorename":true,"client_modified":"2015-05-12T13:50:38Z","mode":"add","path":"/testfile"}`,
+				},
+				RequestData:  content,
+				ResponseData: md,
+			},
+			{
+				t:            t,
+				Method:       "POST",
+				Host:         "api.dropboxapi.com",
+				Path:         "/2/files/get_metadata",
+				RequestData:  []byte(`{"include_deleted":false,"path":"/testfile"}`),
+				ResponseData: md,
+			},
+		}},
+	}
+	if entry, err = db.FilesPutClientMtime(ioutil.NopCloser(bytes.NewReader(content)), int64(len(content)), "testfile", false, "", mtime); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if !time.Time(entry.ClientMtime).Equal(time.Time(expected)) {
+		t.Errorf("got %s expected %s", time.Time(entry.ClientMtime), time.Time(expected))
+	}
+	if entry, err = db.Metadata("testfile", false, false, "", "", 0); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if !time.Time(entry.ClientMtime).Equal(time.Time(expected)) {
+		t.Errorf("got %s expected %s", time.Time(entry.ClientMtime), time.Time(expected))
+	}
+}
+
+func TestPromotedHelpersV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var content []byte
+	var index int
+
+	content = []byte("file content")
+	dir, err := ioutil.TempDir("", "dropbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err = ioutil.WriteFile(src, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db = newDropboxV2(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			{
+				t:      t,
+				Method: "POST",
+				Host:   "content.dropboxapi.com",
+				Path:   "/2/files/upload",
+				Headers: map[string]string{
+					"Dropbox-API-Arg": `{"autorename":true,"mode":"add","path":"/testfile"}`,
+				},
+				RequestData:  content,
+				ResponseData: []byte(`{"name": "testfile", "path_display": "/testfile", "rev": "a1c10ce0dd79", "size": 12}`),
+			},
+			{
+				t:            t,
+				Method:       "POST",
+				Host:         "content.dropboxapi.com",
+				Path:         "/2/files/download",
+				Headers:      map[string]string{"Dropbox-API-Arg": `{"path":"/testfile"}`},
+				ResponseData: content,
+			},
+			{
+				t:            t,
+				Method:       "POST",
+				Host:         "api.dropboxapi.com",
+				Path:         "/2/files/get_metadata",
+				RequestData:  []byte(`{"include_deleted":false,"path":"/testfile"}`),
+				ResponseData: []byte(`{".tag": "file", "name": "testfile", "path_display": "/testfile", "size": 12}`),
+			},
+		}},
+	}
+	if _, err = db.UploadFile(src, "testfile", false, ""); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if err = db.DownloadToFile("testfile", dst, ""); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if got, _ := ioutil.ReadFile(dst); !bytes.Equal(got, content) {
+		t.Errorf("got %q expected %q", got, content)
+	}
+	if err = db.Walk("testfile", func(path string, entry *Entry, err error) error { return err }); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+	if index != 3 {
+		t.Errorf("got %d requests expected 3", index)
+	}
+}
+
+func TestDownloadHelpersV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var data []byte
+	var entry *Entry
+	var body io.ReadCloser
+	var size int64
+
+	content := []byte("file content")
+	fake := FakeHTTP{
+		t:      t,
+		Method: "POST",
+		Host:   "content.dropboxapi.com",
+		Path:   "/2/files/download",
+		Headers: map[string]string{
+			"Dropbox-API-Arg": `{"path":"/testfile"}`,
+		},
+		ResponseHeader: http.Header{"Dropbox-Api-Result": {`{"name": "testfile", "path_display": "/testfile", "size": 12, "content_hash": "7010da024b3bbb581ca2e65653e45dee902d73851987b9cfb79c6583bfeec6f1"}`}},
+		ResponseData:   content,
+	}
+	db = newDropboxV2(t)
+
+	http.DefaultClient = &http.Client{Transport: fake}
+	if data, entry, err = db.GetFile("testfile", ""); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if !bytes.Equal(data, content) || entry == nil || entry.Path != "/testfile" {
+		t.Errorf("got %q, %#v expected the content and the metadata of /testfile", data, entry)
+	}
+
+	dir, err := ioutil.TempDir("", "dropbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = db.DownloadToFileVerified("testfile", filepath.Join(dir, "testfile"), "", ""); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+
+	fake.Headers["Range"] = "bytes=5-7"
+	fake.ResponseData = content[5:8]
+	fake.StatusCode = http.StatusPartialContent
+	http.DefaultClient = &http.Client{Transport: fake}
+	if body, size, err = db.DownloadRange("testfile", "", 5, 7); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	defer body.Close()
+	if data, err = ioutil.ReadAll(body); err != nil || string(data) != "con" || size != 3 {
+		t.Errorf("got %q of %d bytes, %v expected con", data, size, err)
+	}
+}
+
+func TestDownloadSharedLinkV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+
+	db = newDropboxV2(t)
+	fake := FakeHTTP{
+		t:            t,
+		Method:       "POST",
+		Host:         "content.dropboxapi.com",
+		Path:         "/2/sharing/get_shared_link_file",
+		Headers:      map[string]string{"Dropbox-API-Arg": `{"link_password":"secret","url":"https://www.dropbox.com/s/2sn712vy1ovegw8/example.txt?dl=0"}`},
+		ResponseData: []byte("file content"),
+	}
+	http.DefaultClient = &http.Client{Transport: fake}
+	if _, _, err = db.DownloadSharedLink("https://www.dropbox.com/s/2sn712vy1ovegw8/example.txt?dl=0", "secret"); err != nil {
+		t.Errorf("API error: %s", err)
+	}
+
+	fake.StatusCode = http.StatusConflict
+	fake.ResponseData = []byte(`{"error_summary": "shared_link_not_found/..", "error": {".tag": "shared_link_not_found"}}`)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if _, _, err = db.DownloadSharedLink("https://www.dropbox.com/s/2sn712vy1ovegw8/example.txt?dl=0", "secret"); err != os.ErrNotExist {
+		t.Errorf("got %v expected os.ErrNotExist", err)
+	}
+}
+
+func TestListSharedLinksV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var received []Link
+
+	db = newDropboxV2(t)
+	expected := []Link{
+		{URL: "https://www.dropbox.com/s/2sn712vy1ovegw8/Prime_Numbers.txt?dl=0", Visibility: "public"},
+		{URL: "https://www.dropbox.com/s/8xk2zkz0y3ui5bz/Prime_Numbers.txt?dl=0", Visibility: "password",
+			Expires: DBTime(time.Date(2015, time.May, 12, 15, 50, 38, 0, time.UTC))},
+	}
+
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:           t,
+			Method:      "POST",
+			Host:        "api.dropboxapi.com",
+			Path:        "/2/sharing/list_shared_links",
+			RequestData: []byte(`{"direct_only":true,"path":"/Homework/math/Prime_Numbers.txt"}`),
+			ResponseData: []byte(`{"links": [
+				{".tag": "file", "url": "https://www.dropbox.com/s/2sn712vy1ovegw8/Prime_Numbers.txt?dl=0", "link_permissions": {"resolved_visibility": {".tag": "public"}}},
+				{".tag": "file", "url": "https://www.dropbox.com/s/8xk2zkz0y3ui5bz/Prime_Numbers.txt?dl=0", "expires": "2015-05-12T15:50:38Z", "link_permissions": {"resolved_visibility": {".tag": "password"}}}],
+				"has_more": false}`),
+		},
+	}
+
+	if received, err = db.ListSharedLinks("Homework/math/Prime_Numbers.txt"); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, received) {
+		t.Errorf("got %#v expected %#v", received, expected)
+	}
+}
+
+func TestSharesWithSettingsV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var received *Link
+
+	db = newDropboxV2(t)
+	expires := time.Date(2015, time.May, 12, 15, 50, 38, 0, time.UTC)
+	expected := Link{URL: "https://www.dropbox.com/s/2sn712vy1ovegw8/Prime_Numbers.txt?dl=0", Visibility: "password", Expires: DBTime(expires)}
+
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "POST",
+			Host:         "api.dropboxapi.com",
+			Path:         "/2/sharing/create_shared_link_with_settings",
+			RequestData:  []byte(`{"path":"/Prime_Numbers.txt","settings":{"expires":"2015-05-12T15:50:38Z","link_password":"secret","requested_visibility":"password"}}`),
+			ResponseData: []byte(`{".tag": "file", "url": "https://www.dropbox.com/s/2sn712vy1ovegw8/Prime_Numbers.txt?dl=0", "expires": "2015-05-12T15:50:38Z", "link_permissions": {"resolved_visibility": {".tag": "password"}}}`),
+		},
+	}
+
+	settings := ShareSettings{Visibility: "password", Password: "secret", Expires: expires}
+	if received, err = db.SharesWithSettings("Prime_Numbers.txt", settings); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, *received) {
+		t.Errorf("got %#v expected %#v", *received, expected)
+	}
+
+	if _, err = db.Dropbox.SharesWithSettings("Prime_Numbers.txt", settings); err == nil {
+		t.Errorf("settings not supported by the version 1 of the API should be rejected")
+	}
+}
+
+func TestSearchDetailedV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var index int
+	var received *SearchResult
+
+	db = newDropboxV2(t)
+	request := `{"match_field_options":{"include_highlights":true},"options":{"file_status":"%s","max_results":10,"path":"/dummy"},"query":"file"}`
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{
+			index: &index,
+			pages: []FakeHTTP{
+				{
+					t:           t,
+					Method:      "POST",
+					Host:        "api.dropboxapi.com",
+					Path:        "/2/files/search_v2",
+					RequestData: []byte(fmt.Sprintf(request, "active")),
+					ResponseData: []byte(`{"has_more": false, "matches": [
+						{"match_type": {".tag": "filename"}, "metadata": {".tag": "metadata", "metadata": {".tag": "file", "path_display": "/dummy/my file", "rev": "a1"}},
+						 "highlight_spans": [{"highlight_str": "my ", "is_highlighted": false}, {"highlight_str": "file", "is_highlighted": true}]},
+						{"match_type": {".tag": "file_content"}, "metadata": {".tag": "metadata", "metadata": {".tag": "file", "path_display": "/dummy/a", "rev": "a2"}}}]}`),
+				},
+				{
+					t:           t,
+					Method:      "POST",
+					Host:        "api.dropboxapi.com",
+					Path:        "/2/files/search_v2",
+					RequestData: []byte(fmt.Sprintf(request, "deleted")),
+					ResponseData: []byte(`{"has_more": true, "matches": [
+						{"match_type": {".tag": "filename"}, "metadata": {".tag": "metadata", "metadata": {".tag": "deleted", "path_display": "/dummy/old file"}},
+						 "highlight_spans": [{"highlight_str": "old ", "is_highlighted": false}, {"highlight_str": "file", "is_highlighted": true}]}]}`),
+				},
+			},
+		},
+	}
+
+	expected := &SearchResult{
+		Matches: []SearchMatch{
+			{Entry: Entry{Path: "/dummy/a", Revision: "a2"}, MatchType: "file_content"},
+			{Entry: Entry{Path: "/dummy/my file", Revision: "a1"}, MatchType: "filename",
+				Highlights: []HighlightSpan{{Text: "my "}, {Text: "file", IsHighlighted: true}}},
+		},
+		Deleted: []SearchMatch{
+			{Entry: Entry{Path: "/dummy/old file", IsDeleted: true}, MatchType: "filename",
+				Highlights: []HighlightSpan{{Text: "old "}, {Text: "file", IsHighlighted: true}}},
+		},
+		Truncated: true,
+	}
+	if received, err = db.SearchDetailed("dummy", "file", 10, true); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if !reflect.DeepEqual(expected, received) {
+		t.Errorf("got %#v expected %#v", received, expected)
+	}
+}
+
+func TestTemporaryLinkV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var link *Link
+	var entry *Entry
+
+	db = newDropboxV2(t)
+	modified := time.Date(2015, time.May, 12, 15, 50, 38, 0, time.UTC)
+	expected := Entry{Path: "/Homework/math/Prime_Numbers.txt", Bytes: 7212, Revision: "a1c10ce0dd78",
+		ContentHash: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		ClientMtime: DBTime(modified), Modified: DBTime(modified)}
+
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:           t,
+			Method:      "POST",
+			Host:        "api.dropboxapi.com",
+			Path:        "/2/files/get_temporary_link",
+			RequestData: []byte(`{"path":"/Homework/math/Prime_Numbers.txt"}`),
+			ResponseData: []byte(`{"metadata": {".tag": "file", "name": "Prime_Numbers.txt", "path_lower": "/homework/math/prime_numbers.txt",
+				"path_display": "/Homework/math/Prime_Numbers.txt", "client_modified": "2015-05-12T15:50:38Z",
+				"server_modified": "2015-05-12T15:50:38Z", "rev": "a1c10ce0dd78", "size": 7212,
+				"content_hash": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+				"link": "https://dl.dropboxusercontent.com/apitl/1/YXNkZmFzZGcyMzQyMzI0NjU2NDU2NDU2"}`),
+		},
+	}
+
+	before := time.Now().Add(TemporaryLinkLifetime - time.Second)
+	if link, entry, err = db.TemporaryLink("Homework/math/Prime_Numbers.txt"); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if link.URL != "https://dl.dropboxusercontent.com/apitl/1/YXNkZmFzZGcyMzQyMzI0NjU2NDU2NDU2" {
+		t.Errorf("wrong link %q", link.URL)
+	}
+	if expires := time.Time(link.Expires); expires.Before(before) || expires.After(time.Now().Add(TemporaryLinkLifetime)) {
+		t.Errorf("wrong expiration date %s", expires)
+	}
+	if !reflect.DeepEqual(expected, *entry) {
+		t.Errorf("got %#v expected %#v", *entry, expected)
+	}
+}
+
+func TestTemporaryLink(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var link *Link
+	var url string
+
+	db = newDropbox(t)
+	fake := FakeHTTP{
+		t:            t,
+		Method:       "POST",
+		Host:         "api.dropboxapi.com",
+		Path:         "/2/files/get_temporary_link",
+		RequestData:  []byte(`{"path":"/testfile"}`),
+		ResponseData: []byte(`{"metadata": {".tag": "file", "name": "testfile", "path_display": "/testfile", "size": 12}, "link": "https://dl.dropboxusercontent.com/apitl/1/YXNkZmFzZGcy"}`),
+	}
+	http.DefaultClient = &http.Client{Transport: fake}
+	if link, _, err = db.TemporaryLink("testfile"); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if link.URL != "https://dl.dropboxusercontent.com/apitl/1/YXNkZmFzZGcy" {
+		t.Errorf("wrong link %q", link.URL)
+	}
+	if url, err = db.GetTemporaryLink("testfile"); err != nil || url != link.URL {
+		t.Errorf("got %q, %v expected %q", url, err, link.URL)
+	}
+
+	fake.StatusCode = http.StatusConflict
+	fake.ResponseData = []byte(`{"error_summary": "path/not_found/..", "error": {".tag": "path", "path": {".tag": "not_found"}}}`)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if _, err = db.GetTemporaryLink("testfile"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v expected os.ErrNotExist", err)
+	}
+
+	// The promoted methods use the URL of the DropboxV2.
+	v2 := newDropboxV2(t)
+	v2.APIV2URL = "https://api.example.com/2"
+	fake.Host = "api.example.com"
+	fake.StatusCode = 0
+	fake.ResponseData = []byte(`{"metadata": {".tag": "file", "name": "testfile", "path_display": "/testfile", "size": 12}, "link": "https://dl.dropboxusercontent.com/apitl/1/YXNkZmFzZGcy"}`)
+	http.DefaultClient = &http.Client{Transport: fake}
+	if url, err = v2.GetTemporaryLink("testfile"); err != nil || url != link.URL {
+		t.Errorf("got %q, %v expected %q", url, err, link.URL)
+	}
+}
+
+func TestUploadLargeV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var entry *Entry
+	var index int
+
+	db = newDropboxV2(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: []FakeHTTP{
+			{
+				t:            t,
+				Method:       "POST",
+				Host:         "content.dropboxapi.com",
+				Path:         "/2/files/upload_session/start",
+				Headers:      map[string]string{"Dropbox-API-Arg": `{"close":false}`},
+				RequestData:  []byte("0123"),
+				ResponseData: []byte(`{"session_id": "1234faaf0678bcde"}`),
+			},
+			{
+				t:            t,
+				Method:       "POST",
+				Host:         "content.dropboxapi.com",
+				Path:         "/2/files/upload_session/append_v2",
+				Headers:      map[string]string{"Dropbox-API-Arg": `{"close":false,"cursor":{"session_id":"1234faaf0678bcde","offset":4}}`},
+				RequestData:  []byte("4567"),
+				ResponseData: []byte(`null`),
+			},
+			{
+				t:      t,
+				Method: "POST",
+				Host:   "content.dropboxapi.com",
+				Path:   "/2/files/upload_session/finish",
+				Headers: map[string]string{
+					"Dropbox-API-Arg": `{"commit":{"autorename":false,"client_modified":"2015-05-12T13:50:38Z","mode":"overwrite","path":"/large"},"cursor":{"session_id":"1234faaf0678bcde","offset":8}}`,
+				},
+				RequestData:  []byte("89"),
+				ResponseData: []byte(`{".tag": "file", "name": "large", "path_display": "/large", "rev": "a1c10ce0dd78", "size": 10, "client_modified": "2015-05-12T13:50:38Z"}`),
+			},
+		}},
+	}
+
+	mtime := time.Date(2015, time.May, 12, 15, 50, 38, 500, time.FixedZone("CEST", 2*3600))
+	expected := Entry{Path: "/large", Revision: "a1c10ce0dd78", Bytes: 10, ClientMtime: DBTime(time.Date(2015, time.May, 12, 13, 50, 38, 0, time.UTC))}
+	if entry, err = db.UploadLarge(ioutil.NopCloser(bytes.NewReader([]byte("0123456789"))), 4, "large", true, "", mtime); err != nil {
+		t.Fatalf("API error: %s", err)
+	}
+	if !reflect.DeepEqual(expected, *entry) {
+		t.Errorf("got %#v expected %#v", *entry, expected)
+	}
+	if index != 3 {
+		t.Errorf("got %d requests expected 3", index)
+	}
+}
+
+func TestSetAPIArg(t *testing.T) {
+	var err error
+	var request *http.Request
+	var decoded map[string]string
+
+	arg := map[string]string{"path": "/Café/日本/\U0001f600.txt"}
+	if request, err = http.NewRequest("POST", "https://content.dropboxapi.com/2/files/download", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = setAPIArg(request, arg); err != nil {
+		t.Fatalf("setAPIArg: %s", err)
+	}
+	header := request.Header.Get("Dropbox-API-Arg")
+	if expected := `{"path":"/Caf\u00e9/\u65e5\u672c/\ud83d\ude00.txt"}`; header != expected {
+		t.Errorf("got %s expected %s", header, expected)
+	}
+	if err = json.Unmarshal([]byte(header), &decoded); err != nil {
+		t.Errorf("invalid JSON: %s", err)
+	} else if !reflect.DeepEqual(arg, decoded) {
+		t.Errorf("got %#v expected %#v", decoded, arg)
+	}
+}
+
+func TestSpaceUsageV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var received *SpaceUsage
+
+	db = newDropboxV2(t)
+	for _, tc := range []struct {
+		reply    string
+		expected SpaceUsage
+	}{
+		{
+			reply:    `{"used": 314159265, "allocation": {".tag": "individual", "allocated": 10000000000}}`,
+			expected: SpaceUsage{Used: 314159265, Allocation: SpaceAllocation{Type: "individual", Allocated: 10000000000}},
+		},
+		{
+			reply: `{"used": 314159265, "allocation": {".tag": "team", "used": 27182818284, "allocated": 100000000000,
+				"user_within_team_space_allocated": 5000000000, "user_within_team_space_limit_type": {".tag": "stop_sync"},
+				"user_within_team_space_used_cached": 314159265}}`,
+			expected: SpaceUsage{Used: 314159265, Allocation: SpaceAllocation{Type: "team", Allocated: 100000000000,
+				TeamUsed: 27182818284, UserAllocated: 5000000000, UserLimitType: "stop_sync"}},
+		},
+	} {
+		http.DefaultClient = &http.Client{
+			Transport: FakeHTTP{
+				t:            t,
+				Method:       "POST",
+				Host:         "api.dropboxapi.com",
+				Path:         "/2/users/get_space_usage",
+				RequestData:  []byte("null"),
+				ResponseData: []byte(tc.reply),
+			},
+		}
+		if received, err = db.SpaceUsage(); err != nil {
+			t.Errorf("API error: %s", err)
+		} else if !reflect.DeepEqual(tc.expected, *received) {
+			t.Errorf("got %#v expected %#v", *received, tc.expected)
+		}
+	}
+}
+
+func TestFilesPutConflictV2(t *testing.T) {
+	var err error
+	var db *DropboxV2
+	var ce *ConflictError
+
+	db = newDropboxV2(t)
+	content := []byte("file content")
+	http.DefaultClient = &http.Client{
+		Transport: FakeHTTP{
+			t:            t,
+			Method:       "POST",
+			Host:         "content.dropboxapi.com",
+			Path:         "/2/files/upload",
+			Headers:      map[string]string{"Dropbox-API-Arg": `{"autorename":true,"mode":{".tag":"update","update":"a1c10ce0dd78"},"path":"/testfile"}`},
+			RequestData:  content,
+			StatusCode:   http.StatusConflict,
+			ResponseData: []byte(`{"error_summary": "path/conflict/file/..", "error": {".tag": "path", "reason": {".tag": "conflict", "conflict": {".tag": "file"}}}}`),
+		},
+	}
+	_, err = db.FilesPut(ioutil.NopCloser(bytes.NewReader(content)), int64(len(content)), "testfile", false, "a1c10ce0dd78")
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &ce) {
+		t.Fatalf("got %v expected ErrConflict", err)
+	}
+	if ce.Path != "/testfile" || len(ce.CopyPath) != 0 || !errors.Is(err, ErrTargetExists) {
+		t.Errorf("got %#v expected a rejected upload of /testfile", *ce)
+	}
+}
@@ -0,0 +1,236 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// FS returns a read only file system over the files of the account, it implements fs.ReadDirFS and fs.StatFS.
+// Names are slash separated paths relative to the root without leading slash, "." is the root.
+// Each call to Open or Stat requests the metadata of the file, the content of a file is downloaded on its first read.
+// The files implement io.Seeker, the file system can be served with http.FileServer(http.FS(db.FS())).
+func (db *Dropbox) FS() fs.FS {
+	return &dropboxFS{db: db}
+}
+
+// dropboxFS implements fs.FS with the files of a Dropbox account.
+type dropboxFS struct {
+	db *Dropbox
+}
+
+// metadata returns the metadata of the file name, the content of a directory is included when list is true.
+func (dfs *dropboxFS) metadata(op, name string, list bool) (*Entry, error) {
+	var entry *Entry
+	var err error
+
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		name = ""
+	}
+	if entry, err = dfs.db.base().Metadata(name, list, false, "", "", 0); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fs.ErrNotExist
+		}
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if entry.IsDeleted {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return entry, nil
+}
+
+// Open opens the file or directory name.
+func (dfs *dropboxFS) Open(name string) (fs.File, error) {
+	var entry *Entry
+	var err error
+
+	if entry, err = dfs.metadata("open", name, true); err != nil {
+		return nil, err
+	}
+	if entry.IsDir {
+		return &dropboxDir{info: fileInfo{entry: entry, name: path.Base(name)}, entries: dirEntries(entry)}, nil
+	}
+	return &dropboxFile{db: dfs.db, info: fileInfo{entry: entry, name: path.Base(name)}}, nil
+}
+
+// Stat returns the information about the file or directory name.
+func (dfs *dropboxFS) Stat(name string) (fs.FileInfo, error) {
+	var entry *Entry
+	var err error
+
+	if entry, err = dfs.metadata("stat", name, false); err != nil {
+		return nil, err
+	}
+	return fileInfo{entry: entry, name: path.Base(name)}, nil
+}
+
+// ReadDir returns the content of the directory name sorted by file name.
+func (dfs *dropboxFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entry *Entry
+	var err error
+
+	if entry, err = dfs.metadata("readdir", name, true); err != nil {
+		return nil, err
+	}
+	if !entry.IsDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return dirEntries(entry), nil
+}
+
+// dirEntries returns the children of the directory entry sorted by name.
+func dirEntries(entry *Entry) []fs.DirEntry {
+	rv := make([]fs.DirEntry, 0, len(entry.Contents))
+	for i := range entry.Contents {
+		if !entry.Contents[i].IsDeleted {
+			rv = append(rv, fs.FileInfoToDirEntry(fileInfo{entry: &entry.Contents[i], name: path.Base(entry.Contents[i].Path)}))
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Name() < rv[j].Name() })
+	return rv
+}
+
+// fileInfo implements fs.FileInfo for an Entry.
+type fileInfo struct {
+	entry *Entry
+	name  string
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.entry.Bytes }
+func (fi fileInfo) ModTime() time.Time { return time.Time(fi.entry.Modified) }
+func (fi fileInfo) IsDir() bool        { return fi.entry.IsDir }
+func (fi fileInfo) Sys() interface{}   { return fi.entry }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.entry.IsDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// dropboxFile is a file opened by dropboxFS, its content is downloaded on the first read.
+// It implements io.Seeker, the content is downloaded again from the new offset on the next read.
+type dropboxFile struct {
+	db     *Dropbox
+	info   fileInfo
+	body   io.ReadCloser
+	offset int64
+}
+
+func (f *dropboxFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *dropboxFile) Read(p []byte) (int, error) {
+	var n int
+	var err error
+
+	if f.body == nil {
+		if f.offset >= f.info.entry.Bytes {
+			return 0, io.EOF
+		}
+		if f.body, _, err = f.db.base().Download(f.info.entry.Path, f.info.entry.Revision, f.offset); err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+		}
+	}
+	n, err = f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset of the next read, the current download is closed if the offset changes.
+func (f *dropboxFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.entry.Bytes
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *dropboxFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
+
+// dropboxDir is a directory opened by dropboxFS.
+type dropboxDir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dropboxDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dropboxDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *dropboxDir) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, or all the remaining ones if n <= 0.
+func (d *dropboxDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
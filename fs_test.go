@@ -0,0 +1,160 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// treeHTTP serves the metadata and the content of an in-memory tree of files.
+type treeHTTP struct {
+	files map[string]string // content of the files indexed by path.
+	dirs  map[string]bool   // directories, "" is the root.
+}
+
+func (tr treeHTTP) entry(name string) Entry {
+	modified := DBTime(time.Date(2011, time.August, 10, 18, 21, 30, 0, time.UTC))
+	if tr.dirs[name] {
+		return Entry{Path: "/" + name, IsDir: true, Modified: modified, Root: "auto"}
+	}
+	return Entry{Path: "/" + name, Bytes: int64(len(tr.files[name])), Revision: "1f33043551f", Modified: modified, Root: "auto"}
+}
+
+func (tr treeHTTP) RoundTrip(req *http.Request) (*http.Response, error) {
+	var entry Entry
+	var body []byte
+
+	if name := strings.TrimPrefix(req.URL.Path, "/1/files/auto/"); name != req.URL.Path {
+		if content, ok := tr.files[name]; ok {
+			status := http.StatusOK
+			if offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(req.Header.Get("Range"), "bytes="), "-")); err == nil {
+				content = content[offset:]
+				status = http.StatusPartialContent
+			}
+			return &http.Response{StatusCode: status, ContentLength: int64(len(content)),
+				Body: ioutil.NopCloser(strings.NewReader(content))}, nil
+		}
+	} else if name := strings.TrimPrefix(req.URL.Path, "/1/metadata/auto/"); name != req.URL.Path {
+		if _, ok := tr.files[name]; ok || tr.dirs[name] {
+			entry = tr.entry(name)
+			if entry.IsDir && req.URL.Query().Get("list") == "true" {
+				for child := range tr.files {
+					if path.Dir("/"+child) == "/"+name || (name == "" && !strings.Contains(child, "/")) {
+						entry.Contents = append(entry.Contents, tr.entry(child))
+					}
+				}
+				for child := range tr.dirs {
+					if child != "" && (path.Dir("/"+child) == "/"+name || (name == "" && !strings.Contains(child, "/"))) {
+						entry.Contents = append(entry.Contents, tr.entry(child))
+					}
+				}
+			}
+			body, _ = json.Marshal(entry)
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+		}
+	}
+	return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader(`{"error": "not found"}`))}, nil
+}
+
+func TestFS(t *testing.T) {
+	var err error
+	var data []byte
+
+	db := newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: treeHTTP{
+			files: map[string]string{
+				"hello.txt":         "hello world",
+				"empty":             "",
+				"docs/a.md":         "# A",
+				"docs/sub/deep.txt": "deep content",
+			},
+			dirs: map[string]bool{"": true, "docs": true, "docs/sub": true},
+		},
+	}
+
+	fsys := db.FS()
+	if err = fstest.TestFS(fsys, "hello.txt", "empty", "docs/a.md", "docs/sub/deep.txt"); err != nil {
+		t.Errorf("%s", err)
+	}
+	if data, err = fs.ReadFile(fsys, "docs/sub/deep.txt"); err != nil {
+		t.Errorf("API error: %s", err)
+	} else if string(data) != "deep content" {
+		t.Errorf("got %q expected %q", data, "deep content")
+	}
+	if _, err = fs.Stat(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v expected fs.ErrNotExist", err)
+	}
+	if _, err = fsys.Open("/hello.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got %v expected fs.ErrInvalid", err)
+	}
+}
+
+func TestFSFileServer(t *testing.T) {
+	db := newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: treeHTTP{
+			files: map[string]string{"docs/hello.txt": "hello world"},
+			dirs:  map[string]bool{"": true, "docs": true},
+		},
+	}
+	handler := http.FileServer(http.FS(db.FS()))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/docs/hello.txt", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "hello world" {
+		t.Errorf("got %d %q expected the content of the file", recorder.Code, recorder.Body.String())
+	}
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("got content type %q expected text/plain", ct)
+	}
+
+	request := httptest.NewRequest("GET", "/docs/hello.txt", nil)
+	request.Header.Set("Range", "bytes=6-")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusPartialContent || recorder.Body.String() != "world" {
+		t.Errorf("got %d %q expected the end of the file", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/docs/", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "hello.txt") {
+		t.Errorf("got %d %q expected the listing of the directory", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,124 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MirrorError is the error returned by MirrorDown when some files could not be mirrored, indexed by remote path.
+type MirrorError map[string]error
+
+// Error satisfy the error interface.
+func (me MirrorError) Error() string {
+	var paths []string
+
+	for path := range me {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return fmt.Sprintf("%d entries could not be mirrored, first %s: %s", len(me), paths[0], me[paths[0]])
+}
+
+// MirrorDown downloads the tree rooted at remoteRoot on Dropbox to localRoot on the local disk.
+// Files whose local size and modification time match the remote entry are skipped, the modification
+// time of the files downloaded is set to the one of the remote entry so they are skipped next time.
+// An error on a file or a directory does not stop the mirroring, a MirrorError listing them is returned at the end.
+func (db *Dropbox) MirrorDown(remoteRoot, localRoot string) error {
+	var base string
+
+	root := filepath.Clean(localRoot)
+	failed := MirrorError{}
+	err := db.Walk(remoteRoot, func(path string, entry *Entry, err error) error {
+		if len(base) == 0 {
+			if err != nil {
+				return err
+			}
+			// The path of the root entry has the case used on Dropbox, the one of its children.
+			base = entry.Path
+		}
+		if err != nil {
+			failed[path] = err
+			return nil
+		}
+		if !strings.EqualFold(entry.Path, base) &&
+			!strings.HasPrefix(strings.ToLower(entry.Path), strings.ToLower(strings.TrimSuffix(base, "/"))+"/") {
+			failed[entry.Path] = fmt.Errorf("entry is not in %s", base)
+			return nil
+		}
+		local := filepath.Join(localRoot, filepath.FromSlash(strings.TrimPrefix(entry.Path[len(base):], "/")))
+		if local != root && !strings.HasPrefix(local, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			failed[entry.Path] = fmt.Errorf("entry would be written outside of %s", root)
+			return nil
+		}
+		if entry.IsDir {
+			if err = os.MkdirAll(local, 0755); err != nil {
+				failed[entry.Path] = err
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err = db.mirrorFile(entry, local); err != nil {
+			failed[entry.Path] = err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(failed) != 0 {
+		return failed
+	}
+	return nil
+}
+
+// mirrorFile downloads entry to the local path unless the local file has the same size and modification time.
+func (db *Dropbox) mirrorFile(entry *Entry, local string) error {
+	var mtime time.Time
+	var err error
+
+	if mtime, err = entry.ClientMtimeTime(); err != nil {
+		if mtime, err = entry.ModifiedTime(); err != nil {
+			mtime = time.Time{}
+		}
+	}
+	if fi, err := os.Stat(local); err == nil && !mtime.IsZero() &&
+		fi.Size() == entry.Bytes && fi.ModTime().Equal(mtime) {
+		return nil
+	}
+	if err = db.DownloadToFile(entry.Path, local, ""); err != nil {
+		return err
+	}
+	if mtime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(local, mtime, mtime)
+}
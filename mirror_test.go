@@ -0,0 +1,173 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMirrorDown(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var index int
+	var tmpdir string
+	var me MirrorError
+
+	mtime := time.Date(2011, time.August, 10, 18, 21, 30, 0, time.UTC)
+	params := map[string]string{
+		"list":            "true",
+		"include_deleted": "false",
+		"file_limit":      "10000",
+		"locale":          "en",
+	}
+	pages := []FakeHTTP{
+		{
+			t:      t,
+			Method: "GET",
+			Host:   "api.dropbox.com",
+			Path:   "/1/metadata/auto/backup",
+			Params: params,
+			ResponseData: []byte(`{"path": "/Backup", "is_dir": true, "contents": [
+				{"path": "/Backup/new.txt", "bytes": 11, "client_mtime": "Wed, 10 Aug 2011 18:21:30 +0000"},
+				{"path": "/Backup/sub", "is_dir": true},
+				{"path": "/Backup/missing.txt", "bytes": 4}]}`),
+		},
+		{
+			t:            t,
+			Method:       "GET",
+			Host:         "api-content.dropbox.com",
+			Path:         "/1/files/auto/Backup/new.txt",
+			ResponseData: []byte("new content"),
+		},
+		{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/metadata/auto/Backup/sub",
+			Params:       params,
+			ResponseData: []byte(`{"path": "/Backup/sub", "is_dir": true, "contents": [{"path": "/Backup/sub/same.txt", "bytes": 4, "modified": "Wed, 10 Aug 2011 18:21:30 +0000"}]}`),
+		},
+		{
+			t:            t,
+			Method:       "GET",
+			Host:         "api-content.dropbox.com",
+			Path:         "/1/files/auto/Backup/missing.txt",
+			StatusCode:   http.StatusNotFound,
+			ResponseData: []byte(`{"error": "File not found"}`),
+		},
+	}
+
+	if tmpdir, err = ioutil.TempDir("", "dropbox"); err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	same := filepath.Join(tmpdir, "sub", "same.txt")
+	if err = os.MkdirAll(filepath.Dir(same), 0755); err != nil {
+		t.Fatalf("could not create directory: %s", err)
+	}
+	if err = ioutil.WriteFile(same, []byte("same"), 0644); err != nil {
+		t.Fatalf("could not create file: %s", err)
+	}
+	if err = os.Chtimes(same, mtime, mtime); err != nil {
+		t.Fatalf("could not set modification time: %s", err)
+	}
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: pages},
+	}
+
+	err = db.MirrorDown("backup", tmpdir)
+	if !errors.As(err, &me) || len(me) != 1 || me["/Backup/missing.txt"] == nil {
+		t.Errorf("got %v expected an error for /Backup/missing.txt only", err)
+	}
+	if index != len(pages) {
+		t.Errorf("got %d requests expected %d", index, len(pages))
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(tmpdir, "new.txt")); err != nil || string(content) != "new content" {
+		t.Errorf("got %q, %v expected the downloaded content", content, err)
+	}
+	if fi, err := os.Stat(filepath.Join(tmpdir, "new.txt")); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Errorf("modification time of the downloaded file was not set")
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "missing.txt")); !os.IsNotExist(err) {
+		t.Errorf("no file should be left for a failed download")
+	}
+}
+
+func TestMirrorDownOutsideRoot(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var index int
+	var tmpdir string
+	var me MirrorError
+
+	pages := []FakeHTTP{
+		{
+			t:      t,
+			Method: "GET",
+			Host:   "api.dropbox.com",
+			Path:   "/1/metadata/auto/backup",
+			Params: map[string]string{
+				"list":            "true",
+				"include_deleted": "false",
+				"file_limit":      "10000",
+				"locale":          "en",
+			},
+			ResponseData: []byte(`{"path": "/Backup", "is_dir": true, "contents": [
+				{"path": "/Backupx/sibling.txt", "bytes": 4},
+				{"path": "/Backup/../escape.txt", "bytes": 4}]}`),
+		},
+	}
+
+	if tmpdir, err = ioutil.TempDir("", "dropbox"); err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	local := filepath.Join(tmpdir, "local")
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: pages},
+	}
+
+	err = db.MirrorDown("backup", local)
+	if !errors.As(err, &me) || len(me) != 2 || me["/Backupx/sibling.txt"] == nil || me["/Backup/../escape.txt"] == nil {
+		t.Errorf("got %v expected an error for both entries", err)
+	}
+	if index != len(pages) {
+		t.Errorf("got %d requests expected %d", index, len(pages))
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "escape.txt")); !os.IsNotExist(err) {
+		t.Errorf("no file should be written outside of the local root")
+	}
+}
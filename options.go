@@ -0,0 +1,81 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Option configures a client created by NewDropboxWithOptions or NewDropboxV2WithOptions.
+type Option func(db *Dropbox) error
+
+// WithAppInfo sets the app key and secret, see SetAppInfo.
+func WithAppInfo(key, secret string) Option {
+	return func(db *Dropbox) error {
+		return db.SetAppInfo(key, secret)
+	}
+}
+
+// WithAccessToken sets the OAuth access token, see SetAccessToken.
+func WithAccessToken(token string) Option {
+	return func(db *Dropbox) error {
+		db.SetAccessToken(token)
+		return nil
+	}
+}
+
+// WithLocale sets the locale sent to the API, see SetLocale.
+func WithLocale(locale string) Option {
+	return func(db *Dropbox) error {
+		return db.SetLocale(locale)
+	}
+}
+
+// WithRoot sets the root of the paths, see SetRoot.
+func WithRoot(root Root) Option {
+	return func(db *Dropbox) error {
+		return db.SetRoot(root)
+	}
+}
+
+// WithHTTPClient sets the client used to send the requests, see Dropbox.HTTPClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(db *Dropbox) error {
+		db.HTTPClient = client
+		return nil
+	}
+}
+
+// apply applies opts to db, it stops at the first invalid option.
+func (db *Dropbox) apply(opts []Option) error {
+	for _, opt := range opts {
+		if err := opt(db); err != nil {
+			return fmt.Errorf("invalid option: %w", err)
+		}
+	}
+	return nil
+}
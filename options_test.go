@@ -0,0 +1,90 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	client := &http.Client{}
+	db, err := NewDropboxWithOptions(
+		WithAppInfo("dummyappkey", "dummyappsecret"),
+		WithAccessToken("dummyoauthtoken"),
+		WithLocale("pt-BR"),
+		WithRoot(RootSandbox),
+		WithHTTPClient(client),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if db.config == nil || db.config.ClientID != "dummyappkey" || db.config.ClientSecret != "dummyappsecret" {
+		t.Errorf("app info not set: %#v", db.config)
+	}
+	if db.AccessToken() != "dummyoauthtoken" {
+		t.Errorf("got access token %q expected dummyoauthtoken", db.AccessToken())
+	}
+	if db.Locale != "pt-BR" {
+		t.Errorf("got locale %q expected pt-BR", db.Locale)
+	}
+	if db.RootDirectory != RootSandbox {
+		t.Errorf("got root %q expected sandbox", db.RootDirectory)
+	}
+	if db.HTTPClient != client {
+		t.Errorf("HTTP client not set")
+	}
+
+	// The defaults are kept without options.
+	if db, err = NewDropboxWithOptions(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if db.Locale != "en" || db.RootDirectory != RootAuto || db.HTTPClient != nil {
+		t.Errorf("wrong defaults %q %q %v", db.Locale, db.RootDirectory, db.HTTPClient)
+	}
+
+	v2, err := NewDropboxV2WithOptions(WithLocale("fr"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v2.Locale != "fr" {
+		t.Errorf("got locale %q expected fr", v2.Locale)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"locale": WithLocale("not a locale"),
+		"root":   WithRoot(Root("home")),
+	} {
+		if db, err := NewDropboxWithOptions(opt); err == nil || db != nil {
+			t.Errorf("%s: invalid option must return an error", name)
+		}
+		if db, err := NewDropboxV2WithOptions(WithAccessToken("dummyoauthtoken"), opt); err == nil || db != nil {
+			t.Errorf("%s: invalid option must return an error", name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidatePath checks that path can be used as a destination on Dropbox.
+// A path must not be empty and must not contain backslashes, control characters
+// or components ending with a space.
+func ValidatePath(path string) error {
+	if len(strings.Trim(path, "/")) == 0 {
+		return fmt.Errorf("invalid path %q: path is empty", path)
+	}
+	for _, r := range path {
+		if r == '\\' || r < 0x20 || r == 0x7f {
+			return fmt.Errorf("invalid path %q: disallowed character %q (backslashes and control characters are not allowed)", path, r)
+		}
+	}
+	for _, component := range strings.Split(path, "/") {
+		if strings.HasSuffix(component, " ") {
+			return fmt.Errorf("invalid path %q: component %q ends with a space (trailing spaces are not allowed)", path, component)
+		}
+	}
+	return nil
+}
+
+// cleanPath returns path relative to the root as expected by the API: duplicate slashes are collapsed
+// and the leading and trailing slashes are removed.
+func cleanPath(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.Replace(path, "//", "/", -1)
+	}
+	return strings.Trim(path, "/")
+}
+
+// NormalizePath returns the form of path used by Dropbox to compare paths, which are case-insensitive but
+// case-preserving: duplicate and trailing slashes are removed, a leading slash is added and the path is lowercased
+// with Unicode case mapping like the paths of DeltaEntry. The root is "/".
+// Two paths designate the same entry when their normalized forms are equal, it can be used as a map key.
+func NormalizePath(path string) string {
+	return "/" + strings.ToLower(cleanPath(path))
+}
+
+// Name returns the last component of the path of this entry, like path.Base it is "/" for the root.
+// The case is the one of Path.
+func (e *Entry) Name() string {
+	p := cleanPath(e.Path)
+	if len(p) == 0 {
+		return "/"
+	}
+	return p[strings.LastIndex(p, "/")+1:]
+}
+
+// Parent returns the absolute path of the directory containing this entry, like path.Dir it is "/" for the root.
+func (e *Entry) Parent() string {
+	p := cleanPath(e.Path)
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		return "/" + p[:i]
+	}
+	return "/"
+}
@@ -0,0 +1,153 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestValidatePath(t *testing.T) {
+	tab := []struct {
+		path  string
+		valid bool
+	}{
+		{"testfile", true},
+		{"/testdir/my report #2.txt", true},
+		{"/été/日本語.txt", true},
+		{"", false},
+		{"/", false},
+		{"/testdir\\testfile", false},
+		{"/test\nfile", false},
+		{"/test\x7ffile", false},
+		{"/testfile ", false},
+		{"/testdir /testfile", false},
+	}
+
+	for _, testCase := range tab {
+		if err := ValidatePath(testCase.path); (err == nil) != testCase.valid {
+			t.Errorf("ValidatePath(%q) returned %v", testCase.path, err)
+		}
+	}
+}
+
+// urlRecorder records the URL of the requests and replies with an empty object.
+type urlRecorder struct {
+	urls *[]string
+}
+
+func (u urlRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	*u.urls = append(*u.urls, req.Method+" "+req.URL.String())
+	return &http.Response{Status: "200 OK", StatusCode: http.StatusOK,
+		Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Body: ioutil.NopCloser(bytes.NewReader([]byte("{}")))}, nil
+}
+
+func TestCleanPath(t *testing.T) {
+	var urls []string
+
+	db := newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: urlRecorder{urls: &urls},
+	}
+	tab := map[string]func(path string){
+		"FilesPut": func(path string) {
+			db.FilesPut(ioutil.NopCloser(bytes.NewReader(nil)), 0, path, false, "")
+		},
+		"CommitChunkedUpload": func(path string) { db.CommitChunkedUpload("id", path, false, "") },
+		"Thumbnails":          func(path string) { db.Thumbnails(path, "", "") },
+		"Preview":             func(path string) { db.Preview(path, "") },
+		"Download":            func(path string) { db.Download(path, "", 0) },
+		"Metadata":            func(path string) { db.Metadata(path, false, false, "", "", 0) },
+		"Search":              func(path string) { db.Search(path, "query", 0, false) },
+		"Shares":              func(path string) { db.Shares(path, false) },
+		"Media":               func(path string) { db.Media(path) },
+		"CopyRef":             func(path string) { db.CopyRef(path) },
+		"Revisions":           func(path string) { db.Revisions(path, 0) },
+		"Restore":             func(path string) { db.Restore(path, "12345") },
+		"Copy":                func(path string) { db.Copy(path, path+".1", false) },
+		"Move":                func(path string) { db.Move(path, path+".1") },
+		"CreateFolder":        func(path string) { db.CreateFolder(path) },
+		"Delete":              func(path string) { db.Delete(path) },
+	}
+
+	for name, call := range tab {
+		urls = nil
+		for _, path := range []string{"dir/foo", "/dir/foo", "//dir//foo"} {
+			call(path)
+		}
+		if len(urls) != 3 {
+			t.Errorf("%s: got %d requests expected 3", name, len(urls))
+			continue
+		}
+		if urls[0] != urls[1] || urls[0] != urls[2] {
+			t.Errorf("%s: got different URLs %q", name, urls)
+		}
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tab := map[string]string{
+		"":                   "/",
+		"/":                  "/",
+		"Docs/Report.PDF":    "/docs/report.pdf",
+		"/Docs//Report.PDF":  "/docs/report.pdf",
+		"//docs/report.pdf/": "/docs/report.pdf",
+		"/Équipe/ÇA.txt":     "/équipe/ça.txt",
+	}
+	for path, expected := range tab {
+		if received := NormalizePath(path); received != expected {
+			t.Errorf("%q: got %q expected %q", path, received, expected)
+		}
+	}
+}
+
+func TestEntryNameParent(t *testing.T) {
+	tab := []struct {
+		path   string
+		name   string
+		parent string
+	}{
+		{"/Photos/Beach.JPG", "Beach.JPG", "/Photos"},
+		{"/Photos/2011/", "2011", "/Photos"},
+		{"/testfile", "testfile", "/"},
+		{"testfile", "testfile", "/"},
+		{"/", "/", "/"},
+		{"", "/", "/"},
+	}
+
+	for _, tc := range tab {
+		entry := Entry{Path: tc.path}
+		if entry.Name() != tc.name {
+			t.Errorf("%q: got name %q expected %q", tc.path, entry.Name(), tc.name)
+		}
+		if entry.Parent() != tc.parent {
+			t.Errorf("%q: got parent %q expected %q", tc.path, entry.Parent(), tc.parent)
+		}
+	}
+}
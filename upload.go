@@ -0,0 +1,163 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrSizeMismatch is the error returned when the content of an upload is not of the size announced.
+var ErrSizeMismatch = errors.New("content size mismatch")
+
+// uploadSource fails when the underlying reader does not yield exactly size bytes.
+type uploadSource struct {
+	r     io.Reader
+	size  int64
+	read  int64
+	start int64 // position of r when the source was created, used to keep read in sync on Seek.
+}
+
+// NewUploadSource returns an io.ReadCloser for FilesPut reading the size bytes of r.
+// Reading fails with ErrSizeMismatch if r yields fewer or more bytes, unless size is negative.
+// The source can be rewound to retry an upload when r implements io.Seeker and r is closed with it when it implements io.Closer.
+func NewUploadSource(r io.Reader, size int64) io.ReadCloser {
+	us := &uploadSource{r: r, size: size}
+	if seeker, ok := r.(io.Seeker); ok {
+		us.start, _ = seeker.Seek(0, io.SeekCurrent)
+	}
+	return us
+}
+
+// Read reads from the underlying reader and checks the number of bytes read against the size.
+func (us *uploadSource) Read(p []byte) (int, error) {
+	var extra [1]byte
+
+	n, err := us.r.Read(p)
+	us.read += int64(n)
+	if us.size < 0 {
+		return n, err
+	}
+	switch {
+	case us.read > us.size:
+		return n, fmt.Errorf("%w: more than %d bytes", ErrSizeMismatch, us.size)
+	case err == io.EOF && us.read < us.size:
+		return n, fmt.Errorf("%w: got %d bytes expected %d", ErrSizeMismatch, us.read, us.size)
+	case err == nil && n > 0 && us.read == us.size:
+		// The reader is not read beyond size by the HTTP client, look for remaining data now.
+		if m, _ := us.r.Read(extra[:]); m > 0 {
+			return n, fmt.Errorf("%w: more than %d bytes", ErrSizeMismatch, us.size)
+		}
+	}
+	return n, err
+}
+
+// Seek sets the offset of the underlying reader, an error is returned if it is not an io.Seeker.
+func (us *uploadSource) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := us.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("upload source is not seekable")
+	}
+	pos, err := seeker.Seek(offset, whence)
+	if err == nil {
+		us.read = pos - us.start
+	}
+	return pos, err
+}
+
+// Close closes the underlying reader if it implements io.Closer.
+func (us *uploadSource) Close() error {
+	if closer, ok := us.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// readAheadBlock is a block read by a readAheadReader.
+type readAheadBlock struct {
+	data []byte
+	err  error
+}
+
+// readAheadReader reads the next block of the underlying reader in a goroutine while the current one is consumed.
+// It is used by chunked uploads to read the next chunk from the disk while the current one is sent, the time
+// spent on an upload is then close to the longest of reading and sending instead of their sum.
+// At most three blocks are kept in memory: the one consumed, the one waiting and the one being read,
+// that is 3*blockSize bytes (about 450MB for blocks of MaxPutFileSize).
+type readAheadReader struct {
+	blocks chan readAheadBlock
+	done   chan struct{}
+	exited chan struct{} // Closed when the goroutine no longer uses the underlying reader.
+	once   sync.Once
+	cur    []byte
+	err    error
+}
+
+func newReadAheadReader(r io.Reader, blockSize int) *readAheadReader {
+	ra := &readAheadReader{blocks: make(chan readAheadBlock, 1), done: make(chan struct{}), exited: make(chan struct{})}
+	go func() {
+		defer close(ra.exited)
+		for {
+			buf := make([]byte, blockSize)
+			n, err := io.ReadFull(r, buf)
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			select {
+			case ra.blocks <- readAheadBlock{buf[:n], err}:
+			case <-ra.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ra
+}
+
+// Read reads from the blocks read in advance, the error of the underlying reader is returned once they are consumed.
+func (ra *readAheadReader) Read(p []byte) (int, error) {
+	for len(ra.cur) == 0 {
+		if ra.err != nil {
+			return 0, ra.err
+		}
+		b := <-ra.blocks
+		ra.cur, ra.err = b.data, b.err
+	}
+	n := copy(p, ra.cur)
+	ra.cur = ra.cur[n:]
+	return n, nil
+}
+
+// stop ends the goroutine reading in advance and waits for it to return from its current read,
+// the underlying reader can then be used again.
+func (ra *readAheadReader) stop() {
+	ra.once.Do(func() { close(ra.done) })
+	<-ra.exited
+}
@@ -0,0 +1,138 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"path/filepath"
+)
+
+// WalkFunc is the type of the function called by Walk for each file or directory.
+// If err is not nil, the metadata of path could not be read and entry may be nil.
+// Returning filepath.SkipDir for a directory skips its content, for a file it skips the remaining files of its directory.
+type WalkFunc func(path string, entry *Entry, err error) error
+
+// Walk walks the tree rooted at root calling fn for each file or directory in the tree, including root.
+// The metadata of each directory is requested with Metadata, an error reading a directory is given to fn
+// and the walk continues unless fn returns an error other than filepath.SkipDir.
+//
+// The tree is walked one directory at a time: the Contents of a directory entry is only valid during the call
+// to fn and is released once its children have been walked. At most WalkLimit listings (1 if not set) are
+// requested in parallel, the next subdirectories of a directory being listed ahead of their turn, so the memory
+// used is bounded by WalkLimit listings per level of depth. When the walk stops early, the listings already
+// requested complete in the background.
+func (db *Dropbox) Walk(root string, fn WalkFunc) error {
+	var entry *Entry
+	var err error
+
+	w := &walker{db: db, fn: fn, limit: db.WalkLimit}
+	if w.limit <= 0 {
+		w.limit = 1
+	}
+	w.sem = make(chan struct{}, w.limit)
+	if entry, err = db.base().Metadata(root, true, false, "", "", 0); err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = w.walk(root, entry)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walker holds the state of a call to Walk.
+type walker struct {
+	db    *Dropbox
+	fn    WalkFunc
+	limit int           // Number of subdirectories listed ahead.
+	sem   chan struct{} // Limits the number of listings in flight.
+}
+
+// listing is the result of the asynchronous listing of a directory, available once done is closed.
+type listing struct {
+	entry *Entry
+	err   error
+	done  chan struct{}
+}
+
+// list starts listing the directory path.
+func (w *walker) list(path string) *listing {
+	l := &listing{done: make(chan struct{})}
+	go func() {
+		w.sem <- struct{}{}
+		l.entry, l.err = w.db.base().Metadata(path, true, false, "", "", 0)
+		<-w.sem
+		close(l.done)
+	}()
+	return l
+}
+
+// walk calls fn for path and recursively for each of its children.
+func (w *walker) walk(path string, entry *Entry) error {
+	var subdirs []string
+	var listings []*listing
+	var current int
+	var err error
+
+	if err = w.fn(path, entry, nil); err != nil || !entry.IsDir {
+		return err
+	}
+	children := entry.Contents
+	entry.Contents = nil
+	for i := range children {
+		if children[i].IsDir {
+			subdirs = append(subdirs, children[i].Path)
+		}
+	}
+	for i := range children {
+		child := &children[i]
+		if !child.IsDir {
+			if err = w.fn(child.Path, child, nil); err == filepath.SkipDir {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		// Start the listings of this subdirectory and of the following ones up to the limit.
+		for len(listings) < len(subdirs) && len(listings) < current+w.limit {
+			listings = append(listings, w.list(subdirs[len(listings)]))
+		}
+		l := listings[current]
+		listings[current] = nil
+		current++
+		<-l.done
+		if l.err != nil {
+			err = w.fn(child.Path, child, l.err)
+		} else {
+			err = w.walk(child.Path, l.entry)
+		}
+		if err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// deepTreeHTTP lists a synthetic tree where each directory above depth has width subdirectories and files files.
+type deepTreeHTTP struct {
+	depth int
+	width int
+	files int
+}
+
+func (tr deepTreeHTTP) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+
+	name := strings.TrimPrefix(req.URL.Path, "/1/metadata/auto")
+	entry := Entry{Path: name, IsDir: true}
+	if strings.Count(name, "/") < tr.depth {
+		for i := 0; i < tr.width; i++ {
+			entry.Contents = append(entry.Contents, Entry{Path: fmt.Sprintf("%s/dir%d", name, i), IsDir: true})
+		}
+		for i := 0; i < tr.files; i++ {
+			entry.Contents = append(entry.Contents, Entry{Path: fmt.Sprintf("%s/file%d", name, i), Bytes: 1024})
+		}
+	}
+	body, _ = json.Marshal(entry)
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(string(body)))}, nil
+}
+
+func TestWalk(t *testing.T) {
+	var err error
+	var db *Dropbox
+	var index int
+	var received []string
+
+	params := map[string]string{
+		"list":            "true",
+		"include_deleted": "false",
+		"file_limit":      "10000",
+		"locale":          "en",
+	}
+	pages := []FakeHTTP{
+		{
+			t:      t,
+			Method: "GET",
+			Host:   "api.dropbox.com",
+			Path:   "/1/metadata/auto/testdir",
+			Params: params,
+			ResponseData: []byte(`{"path": "/testdir", "is_dir": true, "contents": [
+				{"path": "/testdir/sub", "is_dir": true},
+				{"path": "/testdir/skipped", "is_dir": true},
+				{"path": "/testdir/testfile", "is_dir": false}]}`),
+		},
+		{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/metadata/auto/testdir/sub",
+			Params:       params,
+			ResponseData: []byte(`{"path": "/testdir/sub", "is_dir": true, "contents": [{"path": "/testdir/sub/otherfile", "is_dir": false}]}`),
+		},
+		{
+			t:            t,
+			Method:       "GET",
+			Host:         "api.dropbox.com",
+			Path:         "/1/metadata/auto/testdir/skipped",
+			Params:       params,
+			ResponseData: []byte(`{"path": "/testdir/skipped", "is_dir": true, "contents": [{"path": "/testdir/skipped/file", "is_dir": false}]}`),
+		},
+	}
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{index: &index, pages: pages},
+	}
+
+	err = db.Walk("testdir", func(path string, entry *Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		received = append(received, path)
+		if path == "/testdir/skipped" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("API error: %s", err)
+	}
+	expected := []string{"testdir", "/testdir/sub", "/testdir/sub/otherfile", "/testdir/skipped", "/testdir/testfile"}
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("got %#v expected %#v", received, expected)
+	}
+}
+
+func TestWalkLimit(t *testing.T) {
+	var expected []string
+
+	http.DefaultClient = &http.Client{Transport: deepTreeHTTP{depth: 3, width: 3, files: 2}}
+	for _, limit := range []int{0, 1, 4} {
+		var received []string
+
+		db := newDropbox(t)
+		db.WalkLimit = limit
+		err := db.Walk("/root", func(path string, entry *Entry, err error) error {
+			if err != nil {
+				return err
+			}
+			received = append(received, path)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("API error: %s", err)
+		}
+		if expected == nil {
+			expected = received
+		} else if !reflect.DeepEqual(expected, received) {
+			t.Errorf("limit %d: got %#v expected %#v", limit, received, expected)
+		}
+	}
+	if len(expected) != 21 {
+		t.Errorf("got %d paths", len(expected))
+	}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	http.DefaultClient = &http.Client{Transport: deepTreeHTTP{depth: 4, width: 4, files: 16}}
+	db := NewDropbox()
+	db.SetAccessToken("dummyoauthtoken")
+	db.WalkLimit = 4
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := db.Walk("/root", func(path string, entry *Entry, err error) error { return err }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Watch long-polls for changes after cursor and sends each modified entry on the returned channel.
+// The delay requested by the server between two polls is honored and the cursor is advanced internally.
+// Both channels are closed when ctx is done or after an error has been sent.
+// When Delta asks for the local state to be cleared, an entry with Reset set is sent before the modified entries.
+func (db *Dropbox) Watch(ctx context.Context, cursor string) (<-chan DeltaEntry, <-chan error) {
+	entries := make(chan DeltaEntry)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(entries)
+
+		for {
+			if err := db.watchOnce(ctx, &cursor, entries); err != nil {
+				if ctx.Err() == nil {
+					errs <- err
+				}
+				return
+			}
+		}
+	}()
+	return entries, errs
+}
+
+// watchOnce waits for changes after cursor and sends them on entries, cursor is then updated.
+func (db *Dropbox) watchOnce(ctx context.Context, cursor *string, entries chan<- DeltaEntry) error {
+	var poll *DeltaPoll
+	var entry *DeltaEntry
+	var reset bool
+	var err error
+
+	if poll, err = db.LongPollDeltaContext(ctx, *cursor, 0); err != nil {
+		return err
+	}
+	if poll.Changes {
+		it := db.DeltaIterator(*cursor, "")
+		for entry, err = it.Next(); err == nil; entry, err = it.Next() {
+			if !reset && it.ShouldReset() {
+				if err = sendDeltaEntry(ctx, entries, DeltaEntry{Reset: true}); err != nil {
+					return err
+				}
+				reset = true
+			}
+			if err = sendDeltaEntry(ctx, entries, *entry); err != nil {
+				return err
+			}
+		}
+		if err != io.EOF {
+			return err
+		}
+		if !reset && it.ShouldReset() {
+			if err = sendDeltaEntry(ctx, entries, DeltaEntry{Reset: true}); err != nil {
+				return err
+			}
+		}
+		*cursor = it.Cursor()
+	}
+	if poll.Backoff > 0 {
+		select {
+		case <-time.After(time.Duration(poll.Backoff) * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return ctx.Err()
+}
+
+// sendDeltaEntry sends entry on entries unless ctx is done first.
+func sendDeltaEntry(ctx context.Context, entries chan<- DeltaEntry, entry DeltaEntry) error {
+	select {
+	case entries <- entry:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
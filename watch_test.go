@@ -0,0 +1,146 @@
+/*
+** Copyright (c) 2014 Arnaud Ysmal.  All Rights Reserved.
+**
+** Redistribution and use in source and binary forms, with or without
+** modification, are permitted provided that the following conditions
+** are met:
+** 1. Redistributions of source code must retain the above copyright
+**    notice, this list of conditions and the following disclaimer.
+** 2. Redistributions in binary form must reproduce the above copyright
+**    notice, this list of conditions and the following disclaimer in the
+**    documentation and/or other materials provided with the distribution.
+**
+** THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS
+** OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+** WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+** DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE
+** FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+** DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+** SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+** HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+** LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+** OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+** SUCH DAMAGE.
+ */
+
+package dropbox
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWatch(t *testing.T) {
+	var db *Dropbox
+	var index int
+	var received []string
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{
+			index: &index,
+			block: true,
+			pages: []FakeHTTP{
+				{
+					t:            t,
+					Method:       "GET",
+					Host:         "api-notify.dropbox.com",
+					Path:         "/1/longpoll_delta",
+					Params:       map[string]string{"cursor": "first"},
+					ResponseData: []byte(`{"changes": true}`),
+				},
+				{
+					t:            t,
+					Method:       "POST",
+					Host:         "api.dropbox.com",
+					Path:         "/1/delta",
+					Params:       map[string]string{"locale": "en", "cursor": "first"},
+					ResponseData: []byte(`{"has_more": false, "cursor": "second", "entries": [["/testfile", null], ["/testdir", null]]}`),
+				},
+				{
+					t:            t,
+					Method:       "GET",
+					Host:         "api-notify.dropbox.com",
+					Path:         "/1/longpoll_delta",
+					Params:       map[string]string{"cursor": "second"},
+					ResponseData: []byte(`{"changes": false}`),
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	entries, errs := db.Watch(ctx, "first")
+	for entry := range entries {
+		received = append(received, entry.Path)
+		if len(received) == 2 {
+			cancel()
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("API error: %s", err)
+	}
+	expected := []string{"/testfile", "/testdir"}
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("got %#v expected %#v", received, expected)
+	}
+}
+
+func TestWatchReset(t *testing.T) {
+	var db *Dropbox
+	var index int
+	var received []DeltaEntry
+
+	db = newDropbox(t)
+	http.DefaultClient = &http.Client{
+		Transport: pagesHTTP{
+			index: &index,
+			block: true,
+			pages: []FakeHTTP{
+				{
+					t:            t,
+					Method:       "GET",
+					Host:         "api-notify.dropbox.com",
+					Path:         "/1/longpoll_delta",
+					Params:       map[string]string{"cursor": "first"},
+					ResponseData: []byte(`{"changes": true}`),
+				},
+				{
+					t:            t,
+					Method:       "POST",
+					Host:         "api.dropbox.com",
+					Path:         "/1/delta",
+					Params:       map[string]string{"locale": "en", "cursor": "first"},
+					ResponseData: []byte(`{"reset": true, "has_more": false, "cursor": "second", "entries": [["/testfile", null]]}`),
+				},
+				{
+					t:            t,
+					Method:       "GET",
+					Host:         "api-notify.dropbox.com",
+					Path:         "/1/longpoll_delta",
+					Params:       map[string]string{"cursor": "second"},
+					ResponseData: []byte(`{"changes": false}`),
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	entries, errs := db.Watch(ctx, "first")
+	for entry := range entries {
+		received = append(received, entry)
+		if len(received) == 2 {
+			cancel()
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("API error: %s", err)
+	}
+	expected := []DeltaEntry{{Reset: true}, {Path: "/testfile"}}
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("got %#v expected %#v", received, expected)
+	}
+}